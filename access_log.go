@@ -0,0 +1,225 @@
+package micro
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// redactedValue - the value to replace the redacted string fields
+const redactedValue = "[REDACTED]"
+
+// GRPCAccessLogOpts - the options of the gRPC access log
+type GRPCAccessLogOpts struct {
+	// the level of the successful calls, LevelInfo by default
+	Level LogLevel
+	// the level of the failed calls
+	ErrorLevel LogLevel
+	// override the level of the successful calls by the full method name, e.g. log the health
+	// checks with LevelDebug
+	MethodLevels map[string]LogLevel
+	// the fraction of the successful calls to be logged, within (0, 1), the others mean log all
+	SampleRate float64
+	// log the request and response messages in JSON
+	LogPayloads bool
+	// the names of the message fields to be redacted in the logged messages, at any depth
+	RedactFields []string
+}
+
+// NewGRPCAccessLogOpts - create the gRPC access log options with the default values
+func NewGRPCAccessLogOpts() *GRPCAccessLogOpts {
+	return &GRPCAccessLogOpts{
+		Level:      LevelInfo,
+		ErrorLevel: LevelError,
+	}
+}
+
+// UnaryAccessLogHandler - the access log interceptor for grpc unary
+func UnaryAccessLogHandler(opts *GRPCAccessLogOpts) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		keyvals := []interface{}{
+			"request_size", messageSize(req),
+			"response_size", messageSize(resp),
+		}
+		if opts.LogPayloads {
+			keyvals = append(keyvals,
+				"request", opts.payload(req),
+				"response", opts.payload(resp),
+			)
+		}
+		opts.log(ctx, info.FullMethod, start, err, keyvals...)
+
+		return resp, err
+	}
+}
+
+// StreamAccessLogHandler - the access log interceptor for grpc stream, the sizes are the total
+// sizes of the received and sent messages
+func StreamAccessLogHandler(opts *GRPCAccessLogOpts) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		wrapped := &sizeServerStream{WrappedServerStream: grpc_middleware.WrapServerStream(stream)}
+		err := handler(srv, wrapped)
+
+		opts.log(stream.Context(), info.FullMethod, start, err,
+			"request_size", wrapped.recvSize,
+			"response_size", wrapped.sentSize,
+			"request_count", wrapped.recvCount,
+			"response_count", wrapped.sentCount,
+		)
+
+		return err
+	}
+}
+
+func (opts *GRPCAccessLogOpts) log(ctx context.Context, method string, start time.Time, err error, keyvals ...interface{}) {
+	code := status.Code(err)
+
+	level := opts.ErrorLevel
+	if code == codes.OK {
+		if opts.SampleRate > 0 && opts.SampleRate < 1 && rand.Float64() >= opts.SampleRate {
+			return
+		}
+
+		level = opts.Level
+		if l, ok := opts.MethodLevels[method]; ok {
+			level = l
+		}
+	}
+
+	var peerAddr string
+	if p, ok := peer.FromContext(ctx); ok {
+		peerAddr = p.Addr.String()
+	}
+
+	keyvals = append([]interface{}{
+		"peer", peerAddr,
+		"code", code.String(),
+		"duration", time.Since(start).String(),
+	}, keyvals...)
+	if err != nil {
+		keyvals = append(keyvals, "error", err.Error())
+	}
+
+	// the logger from context carries the request_id and method already
+	logAt(LoggerFromContext(ctx), level, "gRPC access", keyvals...)
+}
+
+// payload - format the message in JSON with the configured fields redacted
+func (opts *GRPCAccessLogOpts) payload(m interface{}) string {
+	msg, ok := m.(proto.Message)
+	if !ok || msg == nil {
+		return ""
+	}
+
+	if len(opts.RedactFields) > 0 {
+		fields := make(map[string]bool, len(opts.RedactFields))
+		for _, f := range opts.RedactFields {
+			fields[f] = true
+		}
+
+		msg = proto.Clone(msg)
+		redactMessage(msg.ProtoReflect(), fields)
+	}
+
+	b, err := protojson.Marshal(msg)
+	if err != nil {
+		return ""
+	}
+
+	return string(b)
+}
+
+// redactMessage - replace the string fields with redactedValue and clear the other fields if their
+// names are in fields, the nested messages are redacted recursively
+func redactMessage(m protoreflect.Message, fields map[string]bool) {
+	var populated []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		populated = append(populated, fd)
+		return true
+	})
+
+	for _, fd := range populated {
+		if fields[string(fd.Name())] {
+			if fd.Kind() == protoreflect.StringKind && fd.Cardinality() != protoreflect.Repeated {
+				m.Set(fd, protoreflect.ValueOfString(redactedValue))
+			} else {
+				m.Clear(fd)
+			}
+			continue
+		}
+
+		switch {
+		case fd.IsMap():
+			if isMessageKind(fd.MapValue().Kind()) {
+				m.Get(fd).Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+					redactMessage(v.Message(), fields)
+					return true
+				})
+			}
+		case fd.IsList():
+			if isMessageKind(fd.Kind()) {
+				list := m.Get(fd).List()
+				for i := 0; i < list.Len(); i++ {
+					redactMessage(list.Get(i).Message(), fields)
+				}
+			}
+		case isMessageKind(fd.Kind()):
+			redactMessage(m.Get(fd).Message(), fields)
+		}
+	}
+}
+
+func isMessageKind(k protoreflect.Kind) bool {
+	return k == protoreflect.MessageKind || k == protoreflect.GroupKind
+}
+
+// messageSize - the size of the message in bytes, 0 if it is not a proto message
+func messageSize(m interface{}) int {
+	if msg, ok := m.(proto.Message); ok && msg != nil {
+		return proto.Size(msg)
+	}
+
+	return 0
+}
+
+// sizeServerStream - the server stream which counts the messages and their sizes
+type sizeServerStream struct {
+	*grpc_middleware.WrappedServerStream
+	recvSize  int
+	sentSize  int
+	recvCount int
+	sentCount int
+}
+
+func (s *sizeServerStream) RecvMsg(m interface{}) error {
+	err := s.WrappedServerStream.RecvMsg(m)
+	if err == nil {
+		s.recvSize += messageSize(m)
+		s.recvCount++
+	}
+
+	return err
+}
+
+func (s *sizeServerStream) SendMsg(m interface{}) error {
+	err := s.WrappedServerStream.SendMsg(m)
+	if err == nil {
+		s.sentSize += messageSize(m)
+		s.sentCount++
+	}
+
+	return err
+}
@@ -0,0 +1,137 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/apipb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeServerStream) RecvMsg(m interface{}) error {
+	return nil
+}
+
+func (s *fakeServerStream) SendMsg(m interface{}) error {
+	return nil
+}
+
+func TestUnaryAccessLogHandler(t *testing.T) {
+	jl := &recordingJaegerLogger{}
+	defer SetStructuredLogger(GetLogger())
+	SetStructuredLogger(NewJaegerLogger(jl))
+
+	opts := NewGRPCAccessLogOpts()
+	opts.LogPayloads = true
+	opts.RedactFields = []string{"version", "request_type_url"}
+	opts.MethodLevels = map[string]LogLevel{"/micro.Test/Debug": LevelDebug}
+	interceptor := UnaryAccessLogHandler(opts)
+
+	ctx := peer.NewContext(context.TODO(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}})
+	req := &apipb.Api{
+		Name:    "micro",
+		Version: "secret-version",
+		Methods: []*apipb.Method{
+			{Name: "Ping", RequestTypeUrl: "secret-url"},
+		},
+	}
+
+	_, err := interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: "/micro.Test/Ping"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return &emptypb.Empty{}, nil
+		})
+	assert.NoError(t, err)
+	assert.Len(t, jl.lines, 1)
+	assert.True(t, strings.HasPrefix(jl.lines[0], "INFO gRPC access peer=10.0.0.1:1234 code=OK duration="))
+	assert.Contains(t, jl.lines[0], "request_size=")
+	assert.Contains(t, jl.lines[0], "micro")
+	assert.Contains(t, jl.lines[0], redactedValue)
+	assert.NotContains(t, jl.lines[0], "secret")
+
+	// the request itself is not modified by the redaction
+	assert.Equal(t, "secret-version", req.Version)
+	assert.Equal(t, "secret-url", req.Methods[0].RequestTypeUrl)
+
+	// the level is overridden by method
+	_, err = interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: "/micro.Test/Debug"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return &emptypb.Empty{}, nil
+		})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(jl.lines[1], "DEBUG gRPC access"))
+
+	// the failed calls are logged with the error level
+	_, err = interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: "/micro.Test/Debug"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Error(codes.NotFound, "not found")
+		})
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(jl.lines[2], "ERROR gRPC access"))
+	assert.Contains(t, jl.lines[2], "code=NotFound")
+	assert.Contains(t, jl.lines[2], "error=rpc error: code = NotFound desc = not found")
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	jl := &recordingJaegerLogger{}
+	defer SetStructuredLogger(GetLogger())
+	SetStructuredLogger(NewJaegerLogger(jl))
+
+	opts := NewGRPCAccessLogOpts()
+	opts.SampleRate = 0.000000001
+	interceptor := UnaryAccessLogHandler(opts)
+	info := &grpc.UnaryServerInfo{FullMethod: "/micro.Test/Ping"}
+
+	// the successful calls are sampled
+	for i := 0; i < 10; i++ {
+		interceptor(context.TODO(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+	}
+	assert.Empty(t, jl.lines)
+
+	// the failed calls are always logged
+	interceptor(context.TODO(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, errors.New("failed")
+	})
+	assert.Len(t, jl.lines, 1)
+	assert.Contains(t, jl.lines[0], "code=Unknown")
+}
+
+func TestStreamAccessLogHandler(t *testing.T) {
+	jl := &recordingJaegerLogger{}
+	defer SetStructuredLogger(GetLogger())
+	SetStructuredLogger(NewJaegerLogger(jl))
+
+	interceptor := StreamAccessLogHandler(NewGRPCAccessLogOpts())
+	stream := &fakeServerStream{ctx: context.TODO()}
+	info := &grpc.StreamServerInfo{FullMethod: "/micro.Test/Stream"}
+
+	msg := &apipb.Api{Name: "micro"}
+	err := interceptor(nil, stream, info, func(srv interface{}, stream grpc.ServerStream) error {
+		stream.RecvMsg(&apipb.Api{})
+		stream.SendMsg(msg)
+		stream.SendMsg(msg)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, jl.lines, 1)
+	assert.Contains(t, jl.lines[0], "INFO gRPC access peer= code=OK")
+	assert.Contains(t, jl.lines[0], "request_size=0 response_size=14 request_count=1 response_count=2")
+}
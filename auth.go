@@ -0,0 +1,214 @@
+package micro
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Principal - the authenticated caller, which can be retrieved with PrincipalFromContext
+type Principal struct {
+	// the identity of the caller, e.g. the user id or the key id
+	Subject string
+	// the roles of the caller, which are checked by the authorization policy
+	Roles []string
+	// the scopes granted to the caller, which are checked by the authorization policy
+	Scopes []string
+	// the custom attributes of the caller, e.g. the claims of the token
+	Claims map[string]interface{}
+}
+
+// Authenticator - authenticate the caller of the gRPC calls and the gateway requests
+type Authenticator interface {
+	// Authenticate - authenticate the caller by the incoming metadata, the keys are in lower case,
+	// the error should be an Unauthenticated status error, the others are converted into it
+	Authenticate(ctx context.Context, md metadata.MD) (*Principal, error)
+}
+
+// AuthenticatorFunc - the function adapter of Authenticator
+type AuthenticatorFunc func(ctx context.Context, md metadata.MD) (*Principal, error)
+
+// Authenticate - call the function
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, md metadata.MD) (*Principal, error) {
+	return f(ctx, md)
+}
+
+// AuthOpts - the options of the authentication
+type AuthOpts struct {
+	// the authenticator of the callers
+	Authenticator Authenticator
+	// the full gRPC methods to skip, e.g. "/grpc.health.v1.Health/Check", the ones ending with "/"
+	// skip all the methods of the service, e.g. "/grpc.health.v1.Health/"
+	SkipMethods []string
+	// the http paths of the gateway to skip, e.g. "/healthz"
+	SkipPaths []string
+}
+
+// NewAuthOpts - create the authentication options which skip the health, the reflection and the
+// metrics by default
+func NewAuthOpts(authenticator Authenticator) *AuthOpts {
+	return &AuthOpts{
+		Authenticator: authenticator,
+		SkipMethods:   defaultAuthSkipMethods(),
+		SkipPaths:     defaultAuthSkipPaths(),
+	}
+}
+
+// defaultAuthSkipMethods - the health and the reflection services
+func defaultAuthSkipMethods() []string {
+	return []string{
+		"/grpc.health.v1.Health/",
+		"/grpc.reflection.v1.ServerReflection/",
+		"/grpc.reflection.v1alpha.ServerReflection/",
+	}
+}
+
+// defaultAuthSkipPaths - the health checks and the metrics
+func defaultAuthSkipPaths() []string {
+	return []string{
+		"/healthz",
+		"/readyz",
+		"/metrics",
+	}
+}
+
+type principalKey struct{}
+
+func contextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext - get the authenticated caller from the context, it is false if the call is
+// not authenticated, e.g. the method is skipped
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// UnaryAuthHandler - the authentication interceptor for grpc unary, the principal is put into the
+// context of the handler
+func UnaryAuthHandler(opts *AuthOpts) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if opts.skipMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		principal, err := opts.authenticate(ctx, incomingMetadata(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(contextWithPrincipal(ctx, principal), req)
+	}
+}
+
+// StreamAuthHandler - the authentication interceptor for grpc stream, the principal is put into
+// the context of the stream
+func StreamAuthHandler(opts *AuthOpts) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if opts.skipMethod(info.FullMethod) {
+			return handler(srv, stream)
+		}
+
+		principal, err := opts.authenticate(stream.Context(), incomingMetadata(stream.Context()))
+		if err != nil {
+			return err
+		}
+
+		wrapped := grpc_middleware.WrapServerStream(stream)
+		wrapped.WrappedContext = contextWithPrincipal(stream.Context(), principal)
+
+		return handler(srv, wrapped)
+	}
+}
+
+// AuthHandler - return a http middleware which authenticates the requests by the headers, the
+// principal is put into the request context, the failed requests are responded with the http status
+// of the gRPC code, e.g. 401 for Unauthenticated
+func AuthHandler(opts *AuthOpts) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if opts.skipPath(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			md := make(metadata.MD, len(r.Header))
+			for k, vs := range r.Header {
+				md.Append(k, vs...)
+			}
+
+			principal, err := opts.authenticate(r.Context(), md)
+			if err != nil {
+				st := status.Convert(err)
+				http.Error(w, st.Message(), runtime.HTTPStatusFromCode(st.Code()))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(contextWithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+func incomingMetadata(ctx context.Context) metadata.MD {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		return md
+	}
+
+	return metadata.MD{}
+}
+
+// authenticate - authenticate the caller by the metadata, the errors are converted into the
+// Unauthenticated status errors unless they are status errors already
+func (opts *AuthOpts) authenticate(ctx context.Context, md metadata.MD) (*Principal, error) {
+	principal, err := opts.Authenticator.Authenticate(ctx, md)
+	if err != nil {
+		if _, ok := status.FromError(err); !ok {
+			err = status.Error(codes.Unauthenticated, err.Error())
+		}
+		LoggerFromContext(ctx).Info("Authentication failed", "error", err)
+		return nil, err
+	}
+	if principal == nil {
+		return nil, status.Error(codes.Unauthenticated, "unauthenticated")
+	}
+
+	return principal, nil
+}
+
+func (opts *AuthOpts) skipMethod(method string) bool {
+	return matchMethod(opts.SkipMethods, method)
+}
+
+func (opts *AuthOpts) skipPath(path string) bool {
+	return matchPath(opts.SkipPaths, path)
+}
+
+// matchMethod - check if the full method is one of the methods, the ones ending with "/" match all
+// the methods of the service
+func matchMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if method == m || (strings.HasSuffix(m, "/") && strings.HasPrefix(method, m)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if path == p {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,152 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// testAuthenticator - accept the bearer token "good" as the subject "alice"
+var testAuthenticator = AuthenticatorFunc(func(ctx context.Context, md metadata.MD) (*Principal, error) {
+	switch auth := md.Get("authorization"); {
+	case len(auth) == 0:
+		return nil, status.Error(codes.Unauthenticated, "missing authorization")
+	case auth[0] == "Bearer good":
+		return &Principal{Subject: "alice"}, nil
+	case auth[0] == "Bearer nil":
+		return nil, nil
+	case auth[0] == "Bearer down":
+		return nil, status.Error(codes.Unavailable, "key server is down")
+	default:
+		return nil, errors.New("invalid token")
+	}
+})
+
+func authContext(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func TestUnaryAuthHandler(t *testing.T) {
+	interceptor := UnaryAuthHandler(NewAuthOpts(testAuthenticator))
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		principal, ok := PrincipalFromContext(ctx)
+		if !ok {
+			return "anonymous", nil
+		}
+		return principal.Subject, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/micro.Test/Ping"}
+
+	resp, err := interceptor(authContext("good"), nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", resp)
+
+	_, err = interceptor(context.Background(), nil, info, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// the plain errors are converted into Unauthenticated
+	_, err = interceptor(authContext("bad"), nil, info, handler)
+	assert.Equal(t, status.Error(codes.Unauthenticated, "invalid token"), err)
+
+	// the status errors are kept
+	_, err = interceptor(authContext("down"), nil, info, handler)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	_, err = interceptor(authContext("nil"), nil, info, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// the health service is skipped by default
+	resp, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "anonymous", resp)
+}
+
+func TestStreamAuthHandler(t *testing.T) {
+	opts := NewAuthOpts(testAuthenticator)
+	opts.SkipMethods = []string{"/micro.Test/Skipped"}
+	interceptor := StreamAuthHandler(opts)
+
+	var subject string
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		if principal, ok := PrincipalFromContext(stream.Context()); ok {
+			subject = principal.Subject
+		}
+		return nil
+	}
+
+	err := interceptor(nil, &fakeServerStream{ctx: authContext("good")}, &grpc.StreamServerInfo{FullMethod: "/micro.Test/Watch"}, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", subject)
+
+	err = interceptor(nil, &fakeServerStream{ctx: authContext("bad")}, &grpc.StreamServerInfo{FullMethod: "/micro.Test/Watch"}, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	err = interceptor(nil, &fakeServerStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/micro.Test/Skipped"}, handler)
+	assert.NoError(t, err)
+
+	// the exact methods do not skip the service
+	err = interceptor(nil, &fakeServerStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/micro.Test/SkippedNot"}, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestAuthHandler(t *testing.T) {
+	handler := AuthHandler(NewAuthOpts(testAuthenticator))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal, ok := PrincipalFromContext(r.Context()); ok {
+			w.Write([]byte(principal.Subject))
+		}
+	}))
+
+	req := httptest.NewRequest("GET", "/ping", nil)
+	req.Header.Set("Authorization", "Bearer good")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "alice", resp.Body.String())
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/ping", nil))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Equal(t, "missing authorization\n", resp.Body.String())
+
+	req = httptest.NewRequest("GET", "/ping", nil)
+	req.Header.Set("Authorization", "Bearer down")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "", resp.Body.String())
+}
+
+func TestAuthentication(t *testing.T) {
+	s := NewService(
+		InProcessGateway(true),
+		Authentication(NewAuthOpts(testAuthenticator)),
+	)
+	startInProcessService(t, s)
+
+	// the gateway request is authenticated by the middleware
+	resp := httptest.NewRecorder()
+	s.gatewayHandler().ServeHTTP(resp, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	req := httptest.NewRequest("GET", "/health", nil)
+	req.Header.Set("Authorization", "Bearer good")
+	resp = httptest.NewRecorder()
+	s.gatewayHandler().ServeHTTP(resp, req)
+	assert.Equal(t, "SERVING", resp.Body.String())
+
+	resp = httptest.NewRecorder()
+	s.gatewayHandler().ServeHTTP(resp, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
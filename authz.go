@@ -0,0 +1,286 @@
+package micro
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"gopkg.in/yaml.v3"
+)
+
+// AuthzRule - the rule of the callers allowed to call a method
+type AuthzRule struct {
+	// allow the callers without principal, e.g. the skipped methods of the authentication
+	Public bool `yaml:"public"`
+	// the caller needs one of the roles, it is not checked if empty
+	Roles []string `yaml:"roles"`
+	// the caller needs all of the scopes, it is not checked if empty
+	Scopes []string `yaml:"scopes"`
+}
+
+// AuthzPolicy - the authorization policy of the gRPC methods, e.g. in YAML
+//
+//	default_deny: true
+//	methods:
+//	  /users.Users/:
+//	    roles: [admin]
+//	  /users.Users/GetUser:
+//	    roles: [admin, user]
+//	    scopes: [users.read]
+//	  /grpc.health.v1.Health/:
+//	    public: true
+type AuthzPolicy struct {
+	// deny the methods without rule, they are allowed otherwise
+	DefaultDeny bool `yaml:"default_deny"`
+	// the rules by the full method names, the ones ending with "/" apply to all the methods of the
+	// service which have no rule of their own
+	Methods map[string]*AuthzRule `yaml:"methods"`
+}
+
+// the authorization decisions in the span tags and the metrics
+const (
+	authzAllow = "allow"
+	authzDeny  = "deny"
+)
+
+var authzDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "grpc_server_authz_decisions_total",
+	Help: "Total number of authorization decisions of the RPCs on the server.",
+}, []string{"grpc_service", "grpc_method", "decision"})
+
+func init() {
+	prometheus.MustRegister(authzDecisions)
+}
+
+// LoadAuthzPolicy - load the authorization policy from the YAML file, the unknown keys and the
+// methods without rule are rejected so that a typo can not leave the policy open
+func LoadAuthzPolicy(path string) (*AuthzPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &AuthzPolicy{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(policy); err != nil {
+		return nil, fmt.Errorf("authz policy %s: %w", path, err)
+	}
+	for method, rule := range policy.Methods {
+		if rule == nil {
+			return nil, fmt.Errorf("authz policy %s: no rule for %s", path, method)
+		}
+	}
+
+	return policy, nil
+}
+
+// AuthzPolicyFromProto - build the authorization policy from the custom method option of the
+// registered services. The option is either a message with the "public", "roles" and "scopes"
+// fields, or a repeated string of the roles, e.g.
+//
+//	message AuthzRule {
+//	  bool public = 1;
+//	  repeated string roles = 2;
+//	  repeated string scopes = 3;
+//	}
+//
+//	extend google.protobuf.MethodOptions {
+//	  AuthzRule authz = 50001;
+//	}
+//
+//	rpc GetUser(GetUserRequest) returns (User) {
+//	  option (authz) = { roles: ["admin", "user"] scopes: ["users.read"] };
+//	}
+func AuthzPolicyFromProto(option protoreflect.ExtensionType) *AuthzPolicy {
+	return authzPolicyFromFiles(protoregistry.GlobalFiles, option)
+}
+
+func authzPolicyFromFiles(files *protoregistry.Files, option protoreflect.ExtensionType) *AuthzPolicy {
+	policy := &AuthzPolicy{Methods: map[string]*AuthzRule{}}
+
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		services := file.Services()
+		for i := 0; i < services.Len(); i++ {
+			methods := services.Get(i).Methods()
+			for j := 0; j < methods.Len(); j++ {
+				method := methods.Get(j)
+				opts := method.Options()
+				if opts == nil || !proto.HasExtension(opts, option) {
+					continue
+				}
+
+				fullMethod := fmt.Sprintf("/%s/%s", method.Parent().FullName(), method.Name())
+				policy.Methods[fullMethod] = authzRuleFromOption(proto.GetExtension(opts, option))
+			}
+		}
+		return true
+	})
+
+	return policy
+}
+
+// authzRuleFromOption - read the rule from the value of the option
+func authzRuleFromOption(value interface{}) *AuthzRule {
+	switch v := value.(type) {
+	case []string:
+		return &AuthzRule{Roles: v}
+	case protoreflect.List:
+		rule := &AuthzRule{}
+		for i := 0; i < v.Len(); i++ {
+			rule.Roles = append(rule.Roles, v.Get(i).String())
+		}
+		return rule
+	case proto.Message:
+		m := v.ProtoReflect()
+		fields := m.Descriptor().Fields()
+		rule := &AuthzRule{}
+		if fd := fields.ByName("public"); fd != nil && fd.Kind() == protoreflect.BoolKind {
+			rule.Public = m.Get(fd).Bool()
+		}
+		rule.Roles = optionStrings(m, fields.ByName("roles"))
+		rule.Scopes = optionStrings(m, fields.ByName("scopes"))
+		return rule
+	}
+
+	return &AuthzRule{}
+}
+
+func optionStrings(m protoreflect.Message, fd protoreflect.FieldDescriptor) []string {
+	if fd == nil || !fd.IsList() || fd.Kind() != protoreflect.StringKind {
+		return nil
+	}
+
+	list := m.Get(fd).List()
+	values := make([]string, 0, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		values = append(values, list.Get(i).String())
+	}
+
+	return values
+}
+
+// rule - the rule of the method or its service, a nil rule of the method falls back to the service
+func (p *AuthzPolicy) rule(method string) *AuthzRule {
+	if rule := p.Methods[method]; rule != nil {
+		return rule
+	}
+
+	service, _ := splitMethodName(method)
+	return p.Methods["/"+service+"/"]
+}
+
+// authorize - check if the caller of the context can call the method, the error is the reason of
+// the denial
+func (p *AuthzPolicy) authorize(ctx context.Context, method string) error {
+	rule := p.rule(method)
+	if rule == nil {
+		if p.DefaultDeny {
+			return status.Errorf(codes.PermissionDenied, "permission denied: no policy for %s", method)
+		}
+		return nil
+	}
+	if rule.Public {
+		return nil
+	}
+
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, "%s requires an authenticated caller", method)
+	}
+
+	if len(rule.Roles) > 0 && !containsAny(principal.Roles, rule.Roles) {
+		return status.Errorf(codes.PermissionDenied, "permission denied: %s requires one of the roles %v", method, rule.Roles)
+	}
+	for _, scope := range rule.Scopes {
+		if !containsString(principal.Scopes, scope) {
+			return status.Errorf(codes.PermissionDenied, "permission denied: %s requires the scope %q", method, scope)
+		}
+	}
+
+	return nil
+}
+
+// check - authorize the call and record the decision in the span and the metrics
+func (p *AuthzPolicy) check(ctx context.Context, method string) error {
+	err := p.authorize(ctx, method)
+
+	decision := authzAllow
+	if err != nil {
+		decision = authzDeny
+		LoggerFromContext(ctx).Info("Authorization denied", "error", err)
+	}
+
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.SetTag("authz.decision", decision)
+		if err != nil {
+			span.SetTag("authz.reason", status.Convert(err).Message())
+		}
+	}
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.String("authz.decision", decision))
+		if err != nil {
+			span.SetAttributes(attribute.String("authz.reason", status.Convert(err).Message()))
+		}
+	}
+
+	service, name := splitMethodName(method)
+	authzDecisions.WithLabelValues(service, name, decision).Inc()
+
+	return err
+}
+
+// UnaryAuthzHandler - the authorization interceptor for grpc unary, it has to be installed after
+// the authentication
+func UnaryAuthzHandler(policy *AuthzPolicy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := policy.check(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuthzHandler - the authorization interceptor for grpc stream, it has to be installed after
+// the authentication
+func StreamAuthzHandler(policy *AuthzPolicy) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := policy.check(stream.Context(), info.FullMethod); err != nil {
+			return err
+		}
+
+		return handler(srv, stream)
+	}
+}
+
+func containsAny(values []string, candidates []string) bool {
+	for _, c := range candidates {
+		if containsString(values, c) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
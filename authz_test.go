@@ -0,0 +1,312 @@
+package micro
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestLoadAuthzPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authz.yaml")
+	err := os.WriteFile(path, []byte(`
+default_deny: true
+methods:
+  /users.Users/:
+    roles: [admin]
+  /users.Users/GetUser:
+    roles: [admin, user]
+    scopes: [users.read]
+  /grpc.health.v1.Health/:
+    public: true
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := LoadAuthzPolicy(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, policy.DefaultDeny)
+	assert.Equal(t, &AuthzRule{Roles: []string{"admin", "user"}, Scopes: []string{"users.read"}}, policy.Methods["/users.Users/GetUser"])
+	assert.Equal(t, &AuthzRule{Public: true}, policy.Methods["/grpc.health.v1.Health/"])
+
+	if err := os.WriteFile(path, []byte("methods: [invalid"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadAuthzPolicy(path)
+	assert.Error(t, err)
+
+	// the unknown keys are rejected
+	if err := os.WriteFile(path, []byte("defaultDeny: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadAuthzPolicy(path)
+	assert.Error(t, err)
+
+	// the methods without rule are rejected
+	if err := os.WriteFile(path, []byte("methods:\n  /users.Users/:\n    roles: [admin]\n  /users.Users/Delete:\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadAuthzPolicy(path)
+	assert.EqualError(t, err, "authz policy "+path+": no rule for /users.Users/Delete")
+}
+
+func TestAuthzPolicyNilRule(t *testing.T) {
+	policy := &AuthzPolicy{
+		Methods: map[string]*AuthzRule{
+			"/users.Users/":       {Roles: []string{"admin"}},
+			"/users.Users/Delete": nil,
+		},
+	}
+
+	// the nil rule falls back to the rule of the service
+	ctx := contextWithPrincipal(context.Background(), &Principal{Subject: "bob", Roles: []string{"user"}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(policy.authorize(ctx, "/users.Users/Delete")))
+}
+
+func TestAuthzPolicyAuthorize(t *testing.T) {
+	policy := &AuthzPolicy{
+		DefaultDeny: true,
+		Methods: map[string]*AuthzRule{
+			"/users.Users/":              {Roles: []string{"admin"}},
+			"/users.Users/GetUser":       {Roles: []string{"admin", "user"}, Scopes: []string{"users.read"}},
+			"/grpc.health.v1.Health/":    {Public: true},
+			"/users.Users/ListCountries": {},
+		},
+	}
+	user := contextWithPrincipal(context.Background(), &Principal{Subject: "alice", Roles: []string{"user"}, Scopes: []string{"users.read"}})
+	admin := contextWithPrincipal(context.Background(), &Principal{Subject: "bob", Roles: []string{"admin"}})
+
+	assert.NoError(t, policy.authorize(user, "/users.Users/GetUser"))
+	assert.Equal(t, status.Error(codes.PermissionDenied, `permission denied: /users.Users/GetUser requires the scope "users.read"`), policy.authorize(admin, "/users.Users/GetUser"))
+
+	// the rule of the service
+	assert.NoError(t, policy.authorize(admin, "/users.Users/DeleteUser"))
+	assert.Equal(t, status.Error(codes.PermissionDenied, "permission denied: /users.Users/DeleteUser requires one of the roles [admin]"), policy.authorize(user, "/users.Users/DeleteUser"))
+
+	// the empty rule allows any authenticated caller
+	assert.NoError(t, policy.authorize(user, "/users.Users/ListCountries"))
+	assert.Equal(t, codes.Unauthenticated, status.Code(policy.authorize(context.Background(), "/users.Users/ListCountries")))
+
+	assert.NoError(t, policy.authorize(context.Background(), "/grpc.health.v1.Health/Check"))
+
+	assert.Equal(t, status.Error(codes.PermissionDenied, "permission denied: no policy for /orders.Orders/GetOrder"), policy.authorize(admin, "/orders.Orders/GetOrder"))
+	policy.DefaultDeny = false
+	assert.NoError(t, policy.authorize(context.Background(), "/orders.Orders/GetOrder"))
+}
+
+func TestUnaryAuthzHandler(t *testing.T) {
+	tracer := mocktracer.New()
+	policy := &AuthzPolicy{Methods: map[string]*AuthzRule{"/micro.Test/": {Roles: []string{"admin"}}}}
+	interceptor := UnaryAuthzHandler(policy)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/micro.Test/Ping"}
+
+	denied := authzDecisions.WithLabelValues("micro.Test", "Ping", authzDeny)
+	allowed := authzDecisions.WithLabelValues("micro.Test", "Ping", authzAllow)
+	deniedBefore, allowedBefore := testutil.ToFloat64(denied), testutil.ToFloat64(allowed)
+
+	span := tracer.StartSpan("Ping")
+	ctx := opentracing.ContextWithSpan(contextWithPrincipal(context.Background(), &Principal{Roles: []string{"user"}}), span)
+	_, err := interceptor(ctx, nil, info, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	span.Finish()
+	assert.Equal(t, "deny", span.(*mocktracer.MockSpan).Tag("authz.decision"))
+	assert.Equal(t, "permission denied: /micro.Test/Ping requires one of the roles [admin]", span.(*mocktracer.MockSpan).Tag("authz.reason"))
+
+	resp, err := interceptor(contextWithPrincipal(context.Background(), &Principal{Roles: []string{"admin"}}), nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+
+	assert.Equal(t, deniedBefore+1, testutil.ToFloat64(denied))
+	assert.Equal(t, allowedBefore+1, testutil.ToFloat64(allowed))
+}
+
+func TestStreamAuthzHandler(t *testing.T) {
+	interceptor := StreamAuthzHandler(&AuthzPolicy{DefaultDeny: true})
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		return nil
+	}
+
+	err := interceptor(nil, &fakeServerStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/micro.Test/Watch"}, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// newAuthzOptionFiles - the files of the test service whose methods have the custom authz option
+func newAuthzOptionFiles(t *testing.T) (*protoregistry.Files, protoreflect.ExtensionType, protoreflect.ExtensionType) {
+	files := &protoregistry.Files{}
+	optionFile, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("authz.proto"),
+		Package:    proto.String("authz"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/descriptor.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Rule"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("public"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_BOOL.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+				{Name: proto.String("roles"), Number: proto.Int32(2), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()},
+				{Name: proto.String("scopes"), Number: proto.Int32(3), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()},
+			},
+		}},
+		Extension: []*descriptorpb.FieldDescriptorProto{
+			{Name: proto.String("rule"), Number: proto.Int32(50001), Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".authz.Rule"), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Extendee: proto.String(".google.protobuf.MethodOptions")},
+			{Name: proto.String("roles"), Number: proto.Int32(50002), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(), Extendee: proto.String(".google.protobuf.MethodOptions")},
+		},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := files.RegisterFile(optionFile); err != nil {
+		t.Fatal(err)
+	}
+	ruleOption := dynamicpb.NewExtensionType(optionFile.Extensions().Get(0))
+	rolesOption := dynamicpb.NewExtensionType(optionFile.Extensions().Get(1))
+
+	rule := dynamicpb.NewMessage(optionFile.Messages().Get(0))
+	rule.Set(rule.Descriptor().Fields().ByName("roles"), protoreflect.ValueOfList(newStringList(rule, "roles", "admin", "user")))
+	rule.Set(rule.Descriptor().Fields().ByName("scopes"), protoreflect.ValueOfList(newStringList(rule, "scopes", "users.read")))
+	getUserOptions := &descriptorpb.MethodOptions{}
+	proto.SetExtension(getUserOptions, ruleOption, rule)
+
+	deleteUserOptions := &descriptorpb.MethodOptions{}
+	roles := rolesOption.New().List()
+	roles.Append(protoreflect.ValueOfString("admin"))
+	proto.SetExtension(deleteUserOptions, rolesOption, roles)
+
+	serviceFile, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("users.proto"),
+		Package:    proto.String("users"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/empty.proto"},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Users"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{Name: proto.String("GetUser"), InputType: proto.String(".google.protobuf.Empty"), OutputType: proto.String(".google.protobuf.Empty"), Options: getUserOptions},
+				{Name: proto.String("DeleteUser"), InputType: proto.String(".google.protobuf.Empty"), OutputType: proto.String(".google.protobuf.Empty"), Options: deleteUserOptions},
+				{Name: proto.String("ListUsers"), InputType: proto.String(".google.protobuf.Empty"), OutputType: proto.String(".google.protobuf.Empty")},
+			},
+		}},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := files.RegisterFile(serviceFile); err != nil {
+		t.Fatal(err)
+	}
+
+	return files, ruleOption, rolesOption
+}
+
+func newStringList(m protoreflect.Message, field protoreflect.Name, values ...string) protoreflect.List {
+	list := m.NewField(m.Descriptor().Fields().ByName(field)).List()
+	for _, v := range values {
+		list.Append(protoreflect.ValueOfString(v))
+	}
+
+	return list
+}
+
+func TestAuthzPolicyFromProto(t *testing.T) {
+	files, ruleOption, rolesOption := newAuthzOptionFiles(t)
+
+	policy := authzPolicyFromFiles(files, ruleOption)
+	assert.Equal(t, map[string]*AuthzRule{
+		"/users.Users/GetUser": {Roles: []string{"admin", "user"}, Scopes: []string{"users.read"}},
+	}, policy.Methods)
+
+	policy = authzPolicyFromFiles(files, rolesOption)
+	assert.Equal(t, map[string]*AuthzRule{
+		"/users.Users/DeleteUser": {Roles: []string{"admin"}},
+	}, policy.Methods)
+
+	// none of the registered services has the option
+	assert.Empty(t, AuthzPolicyFromProto(ruleOption).Methods)
+}
+
+func TestAuthorization(t *testing.T) {
+	authOpts := NewAuthOpts(AuthenticatorFunc(func(ctx context.Context, md metadata.MD) (*Principal, error) {
+		return &Principal{Subject: "alice", Roles: md.Get("x-roles")}, nil
+	}))
+	authOpts.SkipMethods = nil
+	s := NewService(
+		Authentication(authOpts),
+		Authorization(&AuthzPolicy{Methods: map[string]*AuthzRule{
+			"/grpc.health.v1.Health/": {Roles: []string{"ops"}},
+		}}),
+	)
+	addr := startInProcessService(t, s)
+
+	err := checkHealth(t, addr)
+	assert.Equal(t, status.Error(codes.PermissionDenied, "permission denied: /grpc.health.v1.Health/Check requires one of the roles [ops]"), err)
+
+	withRoles := ClientUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(metadata.AppendToOutgoingContext(ctx, "x-roles", "ops"), method, req, reply, cc, opts...)
+	})
+	assert.NoError(t, checkHealth(t, addr, withRoles))
+}
+
+func TestAuthorizationWithHMAC(t *testing.T) {
+	authOpts := NewAuthOpts(AuthenticatorFunc(func(ctx context.Context, md metadata.MD) (*Principal, error) {
+		return &Principal{Subject: "alice", Roles: md.Get("x-roles")}, nil
+	}))
+	authOpts.SkipMethods = nil
+	hmacOpts := NewHMACOpts(testHMACKeys)
+	hmacOpts.SkipMethods = nil
+	s := NewService(
+		Authentication(authOpts),
+		HMACAuthentication(hmacOpts),
+		Authorization(&AuthzPolicy{Methods: map[string]*AuthzRule{
+			"/grpc.health.v1.Health/": {Roles: []string{"ops"}},
+		}}),
+		RouteOpt(Route{
+			Method:  "GET",
+			Pattern: PathPattern("whoami"),
+			Handler: func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+				principal, _ := PrincipalFromContext(r.Context())
+				keyID, _ := HMACKeyIDFromContext(r.Context())
+				fmt.Fprintf(w, "%s %v %s", principal.Subject, principal.Roles, keyID)
+			},
+		}),
+	)
+	addr := startInProcessService(t, s)
+	signer := NewHMACSigner("client", testHMACKeys["client"])
+
+	// the principal of the authentication is kept for the authorization of the signed calls
+	withRoles := ClientUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(metadata.AppendToOutgoingContext(ctx, "x-roles", "ops"), method, req, reply, cc, opts...)
+	})
+	assert.NoError(t, checkHealth(t, addr, withRoles, ClientHMAC(signer)))
+	assert.Equal(t, codes.Unauthenticated, status.Code(checkHealth(t, addr, withRoles)))
+
+	// and so are the gateway requests
+	req := httptest.NewRequest("GET", "/whoami", nil)
+	req.Header.Set("X-Roles", "ops")
+	if !assert.NoError(t, signer.SignRequest(req)) {
+		return
+	}
+	resp := httptest.NewRecorder()
+	s.gatewayHandler().ServeHTTP(resp, req)
+	assert.Equal(t, "alice [ops] client", resp.Body.String())
+}
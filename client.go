@@ -0,0 +1,230 @@
+package micro
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/grpc-ecosystem/grpc-opentracing/go/otgrpc"
+	opentracing "github.com/opentracing/opentracing-go"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// DefaultClientTimeout - the default timeout of the unary calls of the clients created by Dial
+const DefaultClientTimeout = 30 * time.Second
+
+// ClientOption - client functional option of Dial
+type ClientOption func(c *clientConfig)
+
+type clientConfig struct {
+	tracing            TracingMode
+	propagator         propagation.TextMapPropagator
+	timeout            time.Duration
+	credentials        credentials.TransportCredentials
+	dialOptions        []grpc.DialOption
+	unaryInterceptors  []grpc.UnaryClientInterceptor
+	streamInterceptors []grpc.StreamClientInterceptor
+	retryPolicies      map[string]*RetryPolicy
+	hedgingPolicies    map[string]*HedgingPolicy
+	circuitBreaker     *CircuitBreakerOpts
+}
+
+// ClientTracing - return a ClientOption to set the tracing mode, TracingOpenTracing by default
+func ClientTracing(mode TracingMode) ClientOption {
+	return func(c *clientConfig) {
+		c.tracing = mode
+	}
+}
+
+// ClientPropagators - return a ClientOption to set the formats to propagate the trace context, the
+// global propagator is used in the TracingOTel mode if it is not set, and the formats of the global
+// tracer in the TracingOpenTracing mode, where the formats only apply to the jaeger tracer
+func ClientPropagators(formats ...PropagationFormat) ClientOption {
+	return func(c *clientConfig) {
+		c.propagator = NewPropagator(formats...)
+	}
+}
+
+// ClientTimeout - return a ClientOption to set the timeout of the unary calls whose context has no
+// deadline, DefaultClientTimeout by default, 0 means no timeout. The streams are not bounded by it
+func ClientTimeout(timeout time.Duration) ClientOption {
+	return func(c *clientConfig) {
+		c.timeout = timeout
+	}
+}
+
+// ClientTLS - return a ClientOption to connect with TLS, the connection is insecure by default
+func ClientTLS(config *tls.Config) ClientOption {
+	return ClientTransportCredentials(credentials.NewTLS(config))
+}
+
+// ClientTransportCredentials - return a ClientOption to set the transport credentials, it
+// overrides the ones in the dial options
+func ClientTransportCredentials(creds credentials.TransportCredentials) ClientOption {
+	return func(c *clientConfig) {
+		c.credentials = creds
+	}
+}
+
+// ClientDialOption - return a ClientOption to append gRPC dial options
+func ClientDialOption(dialOptions ...grpc.DialOption) ClientOption {
+	return func(c *clientConfig) {
+		c.dialOptions = append(c.dialOptions, dialOptions...)
+	}
+}
+
+// ClientUnaryInterceptor - return a ClientOption to append an unary interceptor, which is called
+// after the built-in ones
+func ClientUnaryInterceptor(unaryInterceptor grpc.UnaryClientInterceptor) ClientOption {
+	return func(c *clientConfig) {
+		c.unaryInterceptors = append(c.unaryInterceptors, unaryInterceptor)
+	}
+}
+
+// ClientStreamInterceptor - return a ClientOption to append a stream interceptor, which is called
+// after the built-in ones
+func ClientStreamInterceptor(streamInterceptor grpc.StreamClientInterceptor) ClientOption {
+	return func(c *clientConfig) {
+		c.streamInterceptors = append(c.streamInterceptors, streamInterceptor)
+	}
+}
+
+// Dial - create a client connection to the target with the same interceptor stack as the service,
+// which applies the default timeout, forwards the request id of the incoming context, propagates
+// the trace context and records the prometheus client metrics, the retry, hedging and circuit
+// breaker policies are applied to the unary calls if they are configured
+func Dial(target string, opts ...ClientOption) (*grpc.ClientConn, error) {
+	return grpc.Dial(target, newClientConfig(opts...).grpcDialOptions(target)...)
+}
+
+// Dial - create a client connection to the target with the tracing mode, the propagators and the
+// gRPC dial options of the service, see Dial. The certificate of the TLS option is only used by the
+// gateway, the transport security of the client is set by ClientTLS
+func (s *Service) Dial(target string, opts ...ClientOption) (*grpc.ClientConn, error) {
+	serviceOpts := append(s.tracingClientOptions(), ClientDialOption(s.grpcDialOptions...))
+
+	return Dial(target, append(serviceOpts, opts...)...)
+}
+
+// tracingClientOptions - the client options of the tracing mode and the propagators of the service
+func (s *Service) tracingClientOptions() []ClientOption {
+	return []ClientOption{
+		ClientTracing(s.tracing),
+		func(c *clientConfig) {
+			c.propagator = s.propagator
+		},
+	}
+}
+
+func newClientConfig(opts ...ClientOption) *clientConfig {
+	c := &clientConfig{timeout: DefaultClientTimeout}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// grpcDialOptions - the gRPC dial options of the config, the interceptors are chained in order of
+// the timeout, the request id, the circuit breaker, the retries, the tracing, the metrics and the
+// custom ones, so that each attempt has its own span and metrics
+func (c *clientConfig) grpcDialOptions(target string) []grpc.DialOption {
+	unaryInterceptors := []grpc.UnaryClientInterceptor{
+		unaryClientTimeout(c.timeout),
+		UnaryClientRequestID,
+	}
+	if c.circuitBreaker != nil {
+		unaryInterceptors = append(unaryInterceptors, unaryClientCircuitBreaker(newCircuitBreaker(target, c.circuitBreaker)))
+	}
+	if len(c.retryPolicies) > 0 || len(c.hedgingPolicies) > 0 {
+		unaryInterceptors = append(unaryInterceptors, unaryClientResilience(c.retryPolicies, c.hedgingPolicies))
+	}
+	streamInterceptors := []grpc.StreamClientInterceptor{
+		StreamClientRequestID,
+	}
+
+	if c.tracing == TracingOTel {
+		var otelOpts []otelgrpc.Option
+		if c.propagator != nil {
+			otelOpts = append(otelOpts, otelgrpc.WithPropagators(c.propagator))
+		}
+		unaryInterceptors = append(unaryInterceptors, otelgrpc.UnaryClientInterceptor(otelOpts...))
+		streamInterceptors = append(streamInterceptors, otelgrpc.StreamClientInterceptor(otelOpts...))
+	} else {
+		tracer := tracerWithPropagator(opentracing.GlobalTracer(), c.propagator)
+		unaryInterceptors = append(unaryInterceptors, otgrpc.OpenTracingClientInterceptor(tracer))
+		streamInterceptors = append(streamInterceptors, otgrpc.OpenTracingStreamClientInterceptor(tracer))
+	}
+
+	unaryInterceptors = append(unaryInterceptors, grpc_prometheus.UnaryClientInterceptor)
+	streamInterceptors = append(streamInterceptors, grpc_prometheus.StreamClientInterceptor)
+
+	// insecure by default, which can be overridden by the dial options
+	dialOptions := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	dialOptions = append(dialOptions, c.dialOptions...)
+	if c.credentials != nil {
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(c.credentials))
+	}
+
+	return append(dialOptions,
+		grpc.WithChainUnaryInterceptor(append(unaryInterceptors, c.unaryInterceptors...)...),
+		grpc.WithChainStreamInterceptor(append(streamInterceptors, c.streamInterceptors...)...),
+	)
+}
+
+// unaryClientTimeout - bound the unary calls without deadline by the timeout
+func unaryClientTimeout(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// UnaryClientRequestID - forward the request id of the context as x-request-id for grpc unary
+func UnaryClientRequestID(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(outgoingContextWithRequestID(ctx), method, req, reply, cc, opts...)
+}
+
+// StreamClientRequestID - forward the request id of the context as x-request-id for grpc stream
+func StreamClientRequestID(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(outgoingContextWithRequestID(ctx), desc, cc, method, opts...)
+}
+
+func outgoingContextWithRequestID(ctx context.Context) context.Context {
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get("x-request-id")) > 0 {
+		return ctx
+	}
+
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		return metadata.AppendToOutgoingContext(ctx, "x-request-id", requestID)
+	}
+
+	return ctx
+}
+
+// upstream - a remote gRPC backend proxied by the gateway
+type upstream struct {
+	name             string
+	target           string
+	reverseProxyFunc ReverseProxyFunc
+	clientOpts       []ClientOption
+}
+
+// upstreamDialOptions - the gRPC dial options of the upstream with the tracing mode and the
+// propagators of the service, see Dial
+func (s *Service) upstreamDialOptions(u upstream) []grpc.DialOption {
+	opts := append(s.tracingClientOptions(), u.clientOpts...)
+
+	return newClientConfig(opts...).grpcDialOptions(u.target)
+}
@@ -0,0 +1,182 @@
+package micro
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// startTestGRPCServer - start a gRPC server with the health service which records the incoming
+// context of the calls
+func startTestGRPCServer(t *testing.T) (string, <-chan context.Context) {
+	calls := make(chan context.Context, 10)
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		calls <- ctx
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	return lis.Addr().String(), calls
+}
+
+func TestDial(t *testing.T) {
+	addr, calls := startTestGRPCServer(t)
+
+	conn, err := Dial(addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	// the request id of the incoming call is forwarded with the default timeout
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-request-id", "uuid"))
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+
+	serverCtx := <-calls
+	md, _ := metadata.FromIncomingContext(serverCtx)
+	assert.Equal(t, []string{"uuid"}, md.Get("x-request-id"))
+	deadline, ok := serverCtx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(DefaultClientTimeout), deadline, 5*time.Second)
+
+	// the request id set explicitly is kept
+	ctx = metadata.AppendToOutgoingContext(contextWithRequestID(context.Background(), "uuid"), "x-request-id", "explicit")
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+
+	md, _ = metadata.FromIncomingContext(<-calls)
+	assert.Equal(t, []string{"explicit"}, md.Get("x-request-id"))
+}
+
+func TestDialOptions(t *testing.T) {
+	addr, calls := startTestGRPCServer(t)
+
+	var intercepted bool
+	conn, err := Dial(addr,
+		ClientTimeout(0),
+		ClientTracing(TracingOTel),
+		ClientPropagators(PropagationB3),
+		ClientUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			intercepted = true
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	_, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.True(t, intercepted)
+
+	// no timeout and no request id
+	serverCtx := <-calls
+	_, ok := serverCtx.Deadline()
+	assert.False(t, ok)
+	md, _ := metadata.FromIncomingContext(serverCtx)
+	assert.Empty(t, md.Get("x-request-id"))
+}
+
+func TestDialTLS(t *testing.T) {
+	addr, _ := startTestGRPCServer(t)
+
+	// the TLS handshake fails with the plaintext server even if the dial options are insecure
+	conn, err := NewService().Dial(addr, ClientTLS(&tls.Config{InsecureSkipVerify: true}))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Error(t, err)
+}
+
+func TestRequestIDFromContext(t *testing.T) {
+	assert.Equal(t, "", RequestIDFromContext(context.Background()))
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-request-id", "incoming"))
+	assert.Equal(t, "incoming", RequestIDFromContext(ctx))
+	assert.Equal(t, "uuid", RequestIDFromContext(contextWithRequestID(ctx, "uuid")))
+}
+
+// healthReverseProxyFunc - proxy GET /<path> to the health service of the backend, like the
+// generated RegisterXXXHandlerFromEndpoint
+func healthReverseProxyFunc(path string) ReverseProxyFunc {
+	return func(ctx context.Context, mux *runtime.ServeMux, grpcHostAndPort string, opts []grpc.DialOption) error {
+		conn, err := grpc.Dial(grpcHostAndPort, opts...)
+		if err != nil {
+			return err
+		}
+		client := healthpb.NewHealthClient(conn)
+
+		return mux.HandlePath("GET", "/"+path, func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			resp, err := client.Check(r.Context(), &healthpb.HealthCheckRequest{})
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte(resp.Status.String()))
+		})
+	}
+}
+
+func TestUpstream(t *testing.T) {
+	addr1, calls1 := startTestGRPCServer(t)
+	addr2, _ := startTestGRPCServer(t)
+
+	s := NewService(
+		Upstream("backend1", addr1, healthReverseProxyFunc("backend1")),
+		Upstream("backend2", addr2, healthReverseProxyFunc("backend2"), ClientTLS(&tls.Config{InsecureSkipVerify: true})),
+	)
+	// the gateway only fronts the upstreams
+	if !assert.NoError(t, s.initGateway("localhost:0", nil)) {
+		return
+	}
+
+	req := httptest.NewRequest("GET", "/backend1", nil)
+	req.Header.Set("X-Request-Id", "uuid")
+	resp := httptest.NewRecorder()
+	s.gatewayHandler().ServeHTTP(resp, req)
+	assert.Equal(t, "SERVING", resp.Body.String())
+
+	// the upstream is called with the client interceptors
+	md, _ := metadata.FromIncomingContext(<-calls1)
+	assert.Equal(t, []string{"uuid"}, md.Get("x-request-id"))
+
+	// the TLS handshake fails with the plaintext backend
+	resp = httptest.NewRecorder()
+	s.gatewayHandler().ServeHTTP(resp, httptest.NewRequest("GET", "/backend2", nil))
+	assert.Equal(t, http.StatusBadGateway, resp.Code)
+}
+
+func TestUpstreamError(t *testing.T) {
+	s := NewService(Upstream("backend", "localhost:0", func(ctx context.Context, mux *runtime.ServeMux, grpcHostAndPort string, opts []grpc.DialOption) error {
+		return errors.New("failed")
+	}))
+
+	assert.EqualError(t, s.initGateway("localhost:0", reverseProxyFunc), "upstream backend: failed")
+}
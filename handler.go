@@ -1,13 +1,18 @@
 package micro
 
 import (
+	"github.com/google/uuid"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 )
 
 var _ grpc.UnaryServerInterceptor = UnaryPanicHandler
 var _ grpc.StreamServerInterceptor = StreamPanicHandler
+var _ grpc.UnaryServerInterceptor = UnaryLoggerHandler
+var _ grpc.StreamServerInterceptor = StreamLoggerHandler
 
 func toPanicError(r interface{}) error {
 	return grpc.Errorf(codes.Internal, "panic: %v", r)
@@ -15,7 +20,12 @@ func toPanicError(r interface{}) error {
 
 // UnaryPanicHandler - panic handler for grpc unary
 func UnaryPanicHandler(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
-	defer handleCrash(func(r interface{}) {
+	var method string
+	if info != nil {
+		method = info.FullMethod
+	}
+
+	defer handleCrash(ctx, method, func(r interface{}) {
 		err = toPanicError(r)
 	})
 
@@ -24,16 +34,62 @@ func UnaryPanicHandler(ctx context.Context, req interface{}, info *grpc.UnarySer
 
 // StreamPanicHandler - panic handler for grpc stream handler
 func StreamPanicHandler(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
-	defer handleCrash(func(r interface{}) {
+	var method string
+	if info != nil {
+		method = info.FullMethod
+	}
+
+	ctx := context.Background()
+	if stream != nil {
+		ctx = stream.Context()
+	}
+
+	defer handleCrash(ctx, method, func(r interface{}) {
 		err = toPanicError(r)
 	})
 
 	return handler(srv, stream)
 }
 
-func handleCrash(handler func(interface{})) {
+func handleCrash(ctx context.Context, method string, handler func(interface{})) {
 	if r := recover(); r != nil {
-		Logger().Infof("Panic caught: %v", r)
+		GetLogger().Error("Panic caught", "request_id", RequestIDFromContext(ctx), "method", method, "panic", r)
 		handler(r)
 	}
 }
+
+// UnaryLoggerHandler - put the logger with the request info into the context for grpc unary,
+// the logger can be retrieved with LoggerFromContext
+func UnaryLoggerHandler(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(contextWithRequestLogger(ctx, info.FullMethod), req)
+}
+
+// StreamLoggerHandler - put the logger with the request info into the context for grpc stream,
+// the logger can be retrieved with LoggerFromContext
+func StreamLoggerHandler(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	wrapped := grpc_middleware.WrapServerStream(stream)
+	wrapped.WrappedContext = contextWithRequestLogger(stream.Context(), info.FullMethod)
+
+	return handler(srv, wrapped)
+}
+
+func contextWithRequestLogger(ctx context.Context, method string) context.Context {
+	var requestID string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get("x-request-id"); len(ids) > 0 {
+			requestID = ids[0]
+		}
+	}
+	if requestID == "" {
+		requestID = uuid.New().String()
+	}
+
+	keyvals := []interface{}{"request_id", requestID}
+	if traceID, spanID := SpanIDs(ctx); traceID != "" {
+		keyvals = append(keyvals, "trace_id", traceID, "span_id", spanID)
+	}
+	keyvals = append(keyvals, "method", method)
+
+	ctx = contextWithRequestID(ctx, requestID)
+	return ContextWithLogger(ctx, GetLogger().With(keyvals...))
+}
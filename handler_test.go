@@ -2,10 +2,14 @@ package micro
 
 import (
 	"context"
+	"fmt"
 	"testing"
 
+	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/stretchr/testify/assert"
+	jaeger "github.com/uber/jaeger-client-go"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 func unaryPanic(ctx context.Context, req interface{}) (interface{}, error) {
@@ -26,3 +30,72 @@ func TestStreamPanicHandler(t *testing.T) {
 	err := StreamPanicHandler(nil, nil, nil, streamPanic)
 	assert.Error(t, err)
 }
+
+func TestPanicHandlerRequestID(t *testing.T) {
+	jl := &recordingJaegerLogger{}
+	defer SetStructuredLogger(GetLogger())
+	SetStructuredLogger(NewJaegerLogger(jl))
+
+	ctx := metadata.NewIncomingContext(context.TODO(), metadata.Pairs("x-request-id", "uuid"))
+	_, err := UnaryPanicHandler(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/micro.Test/Ping"}, unaryPanic)
+	assert.Error(t, err)
+
+	err = StreamPanicHandler(nil, &contextServerStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/micro.Test/Watch"}, streamPanic)
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{
+		"ERROR Panic caught request_id=uuid method=/micro.Test/Ping panic=panic in unary handler",
+		"ERROR Panic caught request_id=uuid method=/micro.Test/Watch panic=panic in steam handler",
+	}, jl.lines)
+}
+
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestUnaryLoggerHandler(t *testing.T) {
+	jl := &recordingJaegerLogger{}
+	defer SetStructuredLogger(GetLogger())
+	SetStructuredLogger(NewJaegerLogger(jl))
+
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+	span := tracer.StartSpan("root")
+	traceID, spanID := SpanIDs(opentracing.ContextWithSpan(context.TODO(), span))
+
+	ctx := opentracing.ContextWithSpan(context.TODO(), span)
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-request-id", "uuid"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/micro.Test/Ping"}
+
+	_, err := UnaryLoggerHandler(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		LoggerFromContext(ctx).Info("hello")
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		fmt.Sprintf("INFO hello request_id=uuid trace_id=%s span_id=%s method=/micro.Test/Ping", traceID, spanID),
+	}, jl.lines)
+}
+
+func TestStreamLoggerHandler(t *testing.T) {
+	jl := &recordingJaegerLogger{}
+	defer SetStructuredLogger(GetLogger())
+	SetStructuredLogger(NewJaegerLogger(jl))
+
+	// the request id is generated if it is absent
+	stream := &contextServerStream{ctx: context.TODO()}
+	info := &grpc.StreamServerInfo{FullMethod: "/micro.Test/Stream"}
+
+	err := StreamLoggerHandler(nil, stream, info, func(srv interface{}, stream grpc.ServerStream) error {
+		LoggerFromContext(stream.Context()).Info("hello")
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, jl.lines, 1)
+	assert.Regexp(t, "^INFO hello request_id=[0-9a-f-]{36} method=/micro.Test/Stream$", jl.lines[0])
+}
@@ -0,0 +1,217 @@
+package micro
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// HealthCheckFunc - a function to check if a dependency is healthy, e.g. ping the database
+type HealthCheckFunc func(ctx context.Context) error
+
+type healthCheck struct {
+	name  string
+	check HealthCheckFunc
+}
+
+// healthServer - the standard gRPC health server which also runs the registered health checks,
+// the status of the whole server is reported with the empty service name and the status of each
+// health check is reported with its name. The checks are run on every Check call and /readyz
+// request, and in background at the interval while the service is running, their results are
+// pushed to the statuses so that Watch reflects them
+type healthServer struct {
+	*health.Server
+	checks   []healthCheck
+	timeout  time.Duration
+	interval time.Duration
+	stopping atomic.Bool
+
+	done      chan struct{}
+	stopped   chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+var _ healthpb.HealthServer = (*healthServer)(nil)
+
+func newHealthServer(checks []healthCheck, timeout time.Duration, interval time.Duration) *healthServer {
+	h := &healthServer{
+		Server:   health.NewServer(),
+		checks:   checks,
+		timeout:  timeout,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+
+	for _, c := range checks {
+		h.SetServingStatus(c.name, healthpb.HealthCheckResponse_SERVING)
+	}
+
+	return h
+}
+
+// Check - implements grpc_health_v1.HealthServer, the health checks are run on every call
+func (h *healthServer) Check(ctx context.Context, in *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	resp, err := h.Server.Check(ctx, in)
+	if err != nil || h.stopping.Load() || !h.hasChecks(in.Service) {
+		return resp, err
+	}
+
+	status, _ := h.runChecks(ctx, in.Service)
+	return &healthpb.HealthCheckResponse{Status: status}, nil
+}
+
+// hasChecks - whether the status of the service is decided by the health checks
+func (h *healthServer) hasChecks(service string) bool {
+	for _, c := range h.checks {
+		if service == "" || service == c.name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runChecks - run the health checks of the service, all of them for the empty service name, and
+// push the results to the statuses, the errors are in the order of the checks
+func (h *healthServer) runChecks(ctx context.Context, service string) (healthpb.HealthCheckResponse_ServingStatus, []error) {
+	status := healthpb.HealthCheckResponse_SERVING
+	errs := make([]error, len(h.checks))
+	for i, c := range h.checks {
+		if service != "" && service != c.name {
+			continue
+		}
+
+		if errs[i] = h.runCheck(ctx, c); errs[i] != nil {
+			GetLogger().Warn("Health check failed", "check", c.name, "error", errs[i])
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+			h.SetServingStatus(c.name, healthpb.HealthCheckResponse_NOT_SERVING)
+		} else {
+			h.SetServingStatus(c.name, healthpb.HealthCheckResponse_SERVING)
+		}
+	}
+
+	if service == "" {
+		h.SetServingStatus("", status)
+	}
+
+	return status, errs
+}
+
+// runCheck - run the health check within the timeout, it fails once the timeout expires even if
+// the check does not respect the context
+func (h *healthServer) runCheck(ctx context.Context, c healthCheck) error {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- c.check(ctx)
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// watch - run the health checks at the interval until stopped
+func (h *healthServer) watch() {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			if !h.stopping.Load() {
+				h.runChecks(context.Background(), "")
+			}
+		}
+	}
+}
+
+// start - run the health checks in background once if there are any
+func (h *healthServer) start() {
+	h.startOnce.Do(func() {
+		if len(h.checks) == 0 || h.interval <= 0 {
+			return
+		}
+
+		h.stopped = make(chan struct{})
+		go func() {
+			defer close(h.stopped)
+			h.watch()
+		}()
+	})
+}
+
+// stop - stop running the health checks in background and wait for the running ones
+func (h *healthServer) stop() {
+	h.stopOnce.Do(func() {
+		close(h.done)
+	})
+
+	h.startOnce.Do(func() {})
+	if h.stopped != nil {
+		<-h.stopped
+	}
+}
+
+// serveLiveness - the handler of /healthz, the service is alive as long as it can respond
+func (h *healthServer) serveLiveness(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok"))
+}
+
+// serveReadiness - the handler of /readyz, the service is ready when it is not shutting down and
+// all the health checks pass within the timeout
+func (h *healthServer) serveReadiness(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := h.Server.Check(r.Context(), &healthpb.HealthCheckRequest{})
+	if err != nil || h.stopping.Load() || (!h.hasChecks("") && resp.Status != healthpb.HealthCheckResponse_SERVING) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("not serving"))
+		return
+	}
+
+	_, errs := h.runChecks(r.Context(), "")
+
+	var body string
+	code := http.StatusOK
+	for i, c := range h.checks {
+		if err := errs[i]; err != nil {
+			code = http.StatusServiceUnavailable
+			body += fmt.Sprintf("[-] %s failed: %v\n", c.name, err)
+		} else {
+			body += fmt.Sprintf("[+] %s ok\n", c.name)
+		}
+	}
+
+	if code == http.StatusOK {
+		body += "ok"
+	}
+
+	w.WriteHeader(code)
+	w.Write([]byte(body))
+}
+
+// shutdown - set all the services to NOT_SERVING so that the load balancers stop sending traffic
+func (h *healthServer) shutdown() {
+	GetLogger().Info("Setting health status to NOT_SERVING")
+	h.stopping.Store(true)
+	h.Shutdown()
+}
@@ -0,0 +1,166 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthServer(t *testing.T) {
+	var dbErr error
+	s := NewService(
+		PreShutdownDelay(0),
+		HealthCheck("db", func(ctx context.Context) error {
+			return dbErr
+		}),
+		HealthCheck("cache", func(ctx context.Context) error {
+			return nil
+		}),
+	)
+
+	ctx := context.TODO()
+	resp, err := s.health.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	resp, err = s.health.Check(ctx, &healthpb.HealthCheckRequest{Service: "db"})
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	_, err = s.health.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+	assert.Error(t, err)
+
+	// readiness follows the health checks while liveness does not
+	recorder := httptest.NewRecorder()
+	s.health.serveReadiness(recorder, httptest.NewRequest("GET", "/readyz", nil), nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "[+] db ok")
+
+	dbErr = errors.New("connection refused")
+
+	resp, err = s.health.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+
+	resp, err = s.health.Check(ctx, &healthpb.HealthCheckRequest{Service: "cache"})
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	recorder = httptest.NewRecorder()
+	s.health.serveReadiness(recorder, httptest.NewRequest("GET", "/readyz", nil), nil)
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "[-] db failed: connection refused")
+
+	recorder = httptest.NewRecorder()
+	s.health.serveLiveness(recorder, httptest.NewRequest("GET", "/healthz", nil), nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// every service is NOT_SERVING once the service stops
+	dbErr = nil
+	s.Stop()
+
+	resp, err = s.health.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+
+	resp, err = s.health.Check(ctx, &healthpb.HealthCheckRequest{Service: "cache"})
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+
+	recorder = httptest.NewRecorder()
+	s.health.serveReadiness(recorder, httptest.NewRequest("GET", "/readyz", nil), nil)
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	s := NewService(
+		HealthCheckTimeout(50*time.Millisecond),
+		// the check does not respect the context
+		HealthCheck("db", func(ctx context.Context) error {
+			<-block
+			return nil
+		}),
+	)
+
+	start := time.Now()
+	resp, err := s.health.Check(context.TODO(), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+	assert.Less(t, time.Since(start), time.Second)
+
+	recorder := httptest.NewRecorder()
+	s.health.serveReadiness(recorder, httptest.NewRequest("GET", "/readyz", nil), nil)
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "[-] db failed: context deadline exceeded")
+}
+
+func TestHealthWatch(t *testing.T) {
+	var failing atomic.Bool
+	s := NewService(
+		PreShutdownDelay(0),
+		HealthCheckInterval(10*time.Millisecond),
+		HealthCheck("db", func(ctx context.Context) error {
+			if failing.Load() {
+				return errors.New("connection refused")
+			}
+			return nil
+		}),
+	)
+
+	httpLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.RunWithListeners(ctx, httpLis, grpcLis, reverseProxyFunc)
+	}()
+	defer func() {
+		cancel()
+		<-errChan
+	}()
+
+	conn, err := Dial(grpcLis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the watchers are notified of the results of the background checks
+	for _, service := range []string{"", "db"} {
+		stream, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+		failing.Store(true)
+		resp, err = stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+
+		failing.Store(false)
+		resp, err = stream.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+	}
+}
@@ -0,0 +1,524 @@
+package micro
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// the headers, or the metadata in lower case, of the HMAC signed requests
+const (
+	HMACKeyIDHeader     = "X-Hmac-Key-Id"
+	HMACTimestampHeader = "X-Hmac-Timestamp"
+	HMACNonceHeader     = "X-Hmac-Nonce"
+	HMACSignatureHeader = "X-Hmac-Signature"
+)
+
+// HMACCanonicalRequest - the string to sign of the request, which is the lines of the method, the
+// path, the unix timestamp in seconds, the nonce and the hex encoded SHA-256 of the body. The
+// signature is the base64 encoded HMAC-SHA256 of it with the secret of the key.
+//
+// The gRPC calls are signed with the method "POST", the full method name as the path, e.g.
+// "/grpc.health.v1.Health/Check", and the deterministic protobuf encoding of the request of the
+// unary calls as the body, the body of the streams is empty.
+func HMACCanonicalRequest(method string, path string, timestamp string, nonce string, body []byte) string {
+	hash := sha256.Sum256(body)
+
+	return method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(hash[:])
+}
+
+func hmacSignature(secret []byte, canonicalRequest string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(canonicalRequest))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// HMACKeyStore - the secrets of the HMAC keys
+type HMACKeyStore interface {
+	// Secret - get the secret of the key id
+	Secret(ctx context.Context, keyID string) ([]byte, error)
+}
+
+// HMACKeys - the static HMAC secrets by the key ids
+type HMACKeys map[string][]byte
+
+// Secret - implements HMACKeyStore
+func (k HMACKeys) Secret(ctx context.Context, keyID string) ([]byte, error) {
+	secret, ok := k[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+
+	return secret, nil
+}
+
+// NonceStore - the store of the used nonces to reject the replayed requests, it should be shared
+// by the instances of the service, e.g. backed by redis, the in-memory one only protects each instance
+type NonceStore interface {
+	// Seen - record the nonce until the expiry and return whether it was recorded already
+	Seen(ctx context.Context, nonce string, expiry time.Time) bool
+}
+
+// NewMemoryNonceStore - create the in-memory nonce store
+func NewMemoryNonceStore() NonceStore {
+	return &memoryNonceStore{nonces: map[string]time.Time{}, now: time.Now}
+}
+
+type memoryNonceStore struct {
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	nextSweep time.Time
+	now       func() time.Time
+}
+
+func (s *memoryNonceStore) Seen(ctx context.Context, nonce string, expiry time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.After(s.nextSweep) {
+		for n, e := range s.nonces {
+			if now.After(e) {
+				delete(s.nonces, n)
+			}
+		}
+		s.nextSweep = now.Add(time.Minute)
+	}
+
+	if e, ok := s.nonces[nonce]; ok && !now.After(e) {
+		return true
+	}
+	s.nonces[nonce] = expiry
+
+	return false
+}
+
+// HMACOpts - the options of the HMAC request verification
+type HMACOpts struct {
+	// the secrets of the keys
+	Keys HMACKeyStore
+	// the maximum difference between the timestamp of the requests and the clock of the server
+	Window time.Duration
+	// the store of the nonces used within the window
+	Nonces NonceStore
+	// the full gRPC methods to skip, see AuthOpts
+	SkipMethods []string
+	// the http paths of the gateway to skip
+	SkipPaths []string
+	// the maximum size of the bodies of the http requests in bytes, which are buffered to verify the
+	// signature, the larger ones are responded with 413. It is unlimited if not positive
+	MaxBodySize int64
+
+	now func() time.Time
+}
+
+// NewHMACOpts - create the HMAC options with 5 minutes window, the in-memory nonce store and 4MB
+// max body size, the health, the reflection and the metrics are skipped by default
+func NewHMACOpts(keys HMACKeyStore) *HMACOpts {
+	return &HMACOpts{
+		Keys:        keys,
+		Window:      5 * time.Minute,
+		Nonces:      NewMemoryNonceStore(),
+		SkipMethods: defaultAuthSkipMethods(),
+		SkipPaths:   defaultAuthSkipPaths(),
+		MaxBodySize: 4 << 20,
+	}
+}
+
+type hmacKeyIDKey struct{}
+
+// contextWithHMACKeyID - put the verified key id into the context, which is also the principal
+// unless the caller is authenticated already, e.g. by Authentication
+func contextWithHMACKeyID(ctx context.Context, keyID string) context.Context {
+	if _, ok := PrincipalFromContext(ctx); !ok {
+		ctx = contextWithPrincipal(ctx, &Principal{Subject: keyID})
+	}
+
+	return context.WithValue(ctx, hmacKeyIDKey{}, keyID)
+}
+
+// HMACKeyIDFromContext - get the key id of the verified HMAC signed call or request from the
+// context, it is false if the call is not verified, e.g. skipped
+func HMACKeyIDFromContext(ctx context.Context) (string, bool) {
+	keyID, ok := ctx.Value(hmacKeyIDKey{}).(string)
+	return keyID, ok
+}
+
+// hmacRequest - the signing headers of a request which are in the window and of a known key
+type hmacRequest struct {
+	keyID     string
+	timestamp string
+	nonce     string
+	signature string
+	signedAt  time.Time
+	secret    []byte
+}
+
+// verify - verify the signature of the request and the replay, the principal is the key id
+func (opts *HMACOpts) verify(ctx context.Context, header func(string) string, method string, path string, body []byte) (*Principal, error) {
+	req, err := opts.verifyHeaders(ctx, header)
+	if err != nil {
+		return nil, err
+	}
+
+	return opts.verifySignature(ctx, req, method, path, body)
+}
+
+// verifyHeaders - check the signing headers, the timestamp and the key before the body is read
+func (opts *HMACOpts) verifyHeaders(ctx context.Context, header func(string) string) (*hmacRequest, error) {
+	keyID, timestamp, nonce, signature := header(HMACKeyIDHeader), header(HMACTimestampHeader), header(HMACNonceHeader), header(HMACSignatureHeader)
+	if keyID == "" || timestamp == "" || nonce == "" || signature == "" {
+		return nil, status.Error(codes.Unauthenticated, "missing HMAC signature")
+	}
+
+	now := time.Now()
+	if opts.now != nil {
+		now = opts.now()
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid HMAC timestamp")
+	}
+	signedAt := time.Unix(seconds, 0)
+	if signedAt.Before(now.Add(-opts.Window)) || signedAt.After(now.Add(opts.Window)) {
+		return nil, status.Error(codes.Unauthenticated, "HMAC timestamp is out of the window")
+	}
+
+	secret, err := opts.Keys.Secret(ctx, keyID)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid HMAC key: %v", err)
+	}
+
+	return &hmacRequest{keyID: keyID, timestamp: timestamp, nonce: nonce, signature: signature, signedAt: signedAt, secret: secret}, nil
+}
+
+// verifySignature - verify the signature of the body and the replay
+func (opts *HMACOpts) verifySignature(ctx context.Context, req *hmacRequest, method string, path string, body []byte) (*Principal, error) {
+	expected := hmacSignature(req.secret, HMACCanonicalRequest(method, path, req.timestamp, req.nonce, body))
+	if !hmac.Equal([]byte(expected), []byte(req.signature)) {
+		return nil, status.Error(codes.Unauthenticated, "invalid HMAC signature")
+	}
+
+	// the nonce is checked after the signature so that the forged requests can not use up the nonces
+	if opts.Nonces != nil && opts.Nonces.Seen(ctx, req.keyID+":"+req.nonce, req.signedAt.Add(opts.Window)) {
+		return nil, status.Error(codes.Unauthenticated, "replayed HMAC request")
+	}
+
+	return &Principal{Subject: req.keyID}, nil
+}
+
+func (opts *HMACOpts) verifyCall(ctx context.Context, method string, body []byte) (context.Context, error) {
+	md := incomingMetadata(ctx)
+	principal, err := opts.verify(ctx, func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}, "POST", method, body)
+	if err != nil {
+		LoggerFromContext(ctx).Info("HMAC verification failed", "error", err)
+		return nil, err
+	}
+
+	return contextWithHMACKeyID(ctx, principal.Subject), nil
+}
+
+// UnaryHMACHandler - the HMAC verification interceptor for grpc unary, the key id is put into the
+// context, see HMACKeyIDFromContext, and is the subject of the principal unless the caller is
+// authenticated already
+func UnaryHMACHandler(opts *HMACOpts) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if matchMethod(opts.SkipMethods, info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		body, err := hmacMessageBody(req)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to encode the request: %v", err)
+		}
+
+		ctx, err = opts.verifyCall(ctx, info.FullMethod, body)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamHMACHandler - the HMAC verification interceptor for grpc stream, the messages are not signed
+func StreamHMACHandler(opts *HMACOpts) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if matchMethod(opts.SkipMethods, info.FullMethod) {
+			return handler(srv, stream)
+		}
+
+		ctx, err := opts.verifyCall(stream.Context(), info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+
+		wrapped := grpc_middleware.WrapServerStream(stream)
+		wrapped.WrappedContext = ctx
+
+		return handler(srv, wrapped)
+	}
+}
+
+// HMACHandler - return a http middleware which verifies the HMAC signed requests, the path is the
+// request uri with the query, the failed requests are responded with 401. The body is only read
+// after the headers are verified, up to MaxBodySize. The key id is put into the context like
+// UnaryHMACHandler
+func HMACHandler(opts *HMACOpts) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if matchPath(opts.SkipPaths, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			fail := func(err error) {
+				LoggerFromContext(r.Context()).Info("HMAC verification failed", "error", err)
+				st := status.Convert(err)
+				http.Error(w, st.Message(), runtime.HTTPStatusFromCode(st.Code()))
+			}
+
+			req, err := opts.verifyHeaders(r.Context(), r.Header.Get)
+			if err != nil {
+				fail(err)
+				return
+			}
+
+			if opts.MaxBodySize > 0 && r.Body != nil && r.Body != http.NoBody {
+				r.Body = http.MaxBytesReader(w, r.Body, opts.MaxBodySize)
+			}
+			body, err := readBody(r)
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			principal, err := opts.verifySignature(r.Context(), req, r.Method, r.URL.RequestURI(), body)
+			if err != nil {
+				fail(err)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(contextWithHMACKeyID(r.Context(), principal.Subject)))
+		})
+	}
+}
+
+// gatewayDialOptions - the dial options of the gateway to re-sign the calls of the verified http
+// requests with the same keys, the headers of the http requests are not forwarded by the gateway
+func (opts *HMACOpts) gatewayDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+			signer, err := opts.gatewaySigner(ctx)
+			if err != nil {
+				return err
+			}
+			if signer == nil {
+				return invoker(ctx, method, req, reply, cc, callOpts...)
+			}
+			return signer.UnaryClientInterceptor(ctx, method, req, reply, cc, invoker, callOpts...)
+		}),
+		grpc.WithChainStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+			signer, err := opts.gatewaySigner(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if signer == nil {
+				return streamer(ctx, desc, cc, method, callOpts...)
+			}
+			return signer.StreamClientInterceptor(ctx, desc, cc, method, streamer, callOpts...)
+		}),
+	}
+}
+
+// gatewaySigner - the signer of the key verified by HMACHandler, nil if the request is not verified
+func (opts *HMACOpts) gatewaySigner(ctx context.Context) (*HMACSigner, error) {
+	keyID, ok := HMACKeyIDFromContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+
+	secret, err := opts.Keys.Secret(ctx, keyID)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid HMAC key: %v", err)
+	}
+
+	return NewHMACSigner(keyID, secret), nil
+}
+
+// HMACSigner - sign the outgoing http requests and gRPC calls with the HMAC key
+type HMACSigner struct {
+	keyID  string
+	secret []byte
+	now    func() time.Time
+}
+
+// NewHMACSigner - create the signer of the key
+func NewHMACSigner(keyID string, secret []byte) *HMACSigner {
+	return &HMACSigner{keyID: keyID, secret: secret, now: time.Now}
+}
+
+// sign - the signing headers of the request with a new nonce
+func (s *HMACSigner) sign(method string, path string, body []byte) (map[string]string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	nonce := hex.EncodeToString(b)
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+
+	return map[string]string{
+		HMACKeyIDHeader:     s.keyID,
+		HMACTimestampHeader: timestamp,
+		HMACNonceHeader:     nonce,
+		HMACSignatureHeader: hmacSignature(s.secret, HMACCanonicalRequest(method, path, timestamp, nonce, body)),
+	}, nil
+}
+
+// SignRequest - sign the http request, the body is read and restored
+func (s *HMACSigner) SignRequest(r *http.Request) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	headers, err := s.sign(r.Method, r.URL.RequestURI(), body)
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+
+	return nil
+}
+
+// Transport - return a http.RoundTripper which signs the requests, the base is
+// http.DefaultTransport if it is nil
+func (s *HMACSigner) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return hmacTransport{signer: s, base: base}
+}
+
+type hmacTransport struct {
+	signer *HMACSigner
+	base   http.RoundTripper
+}
+
+func (t hmacTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// the round trippers should not modify the request
+	r = r.Clone(r.Context())
+	if err := t.signer.SignRequest(r); err != nil {
+		return nil, err
+	}
+
+	return t.base.RoundTrip(r)
+}
+
+// UnaryClientInterceptor - sign the grpc unary calls
+func (s *HMACSigner) UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	body, err := hmacMessageBody(req)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to encode the request: %v", err)
+	}
+
+	ctx, err = s.outgoingContext(ctx, method, body)
+	if err != nil {
+		return err
+	}
+
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// StreamClientInterceptor - sign the grpc streams, the messages are not signed
+func (s *HMACSigner) StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx, err := s.outgoingContext(ctx, method, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return streamer(ctx, desc, cc, method, opts...)
+}
+
+func (s *HMACSigner) outgoingContext(ctx context.Context, method string, body []byte) (context.Context, error) {
+	headers, err := s.sign("POST", method, body)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to sign the request: %v", err)
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	for k, v := range headers {
+		md.Set(k, v)
+	}
+
+	return metadata.NewOutgoingContext(ctx, md), nil
+}
+
+// ClientHMAC - return a ClientOption to sign the calls with the signer, each attempt of the retries
+// and the hedging is signed with a new nonce
+func ClientHMAC(signer *HMACSigner) ClientOption {
+	return func(c *clientConfig) {
+		c.unaryInterceptors = append(c.unaryInterceptors, signer.UnaryClientInterceptor)
+		c.streamInterceptors = append(c.streamInterceptors, signer.StreamClientInterceptor)
+	}
+}
+
+// hmacMessageBody - the deterministic protobuf encoding of the message, empty if it is not protobuf
+func hmacMessageBody(msg interface{}) ([]byte, error) {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return nil, nil
+	}
+
+	return proto.MarshalOptions{Deterministic: true}.Marshal(m)
+}
+
+// readBody - read the body of the request and restore it for the next reader
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
@@ -0,0 +1,206 @@
+package micro
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var testHMACKeys = HMACKeys{"client": []byte("secret")}
+
+func TestHMACCanonicalRequest(t *testing.T) {
+	assert.Equal(t,
+		"POST\n/v1/users?page=2\n1700000000\nnonce\n"+"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		HMACCanonicalRequest("POST", "/v1/users?page=2", "1700000000", "nonce", []byte("hello")))
+}
+
+func newHMACTestHandler() http.Handler {
+	return HMACHandler(NewHMACOpts(testHMACKeys))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if principal, ok := PrincipalFromContext(r.Context()); ok {
+			w.Write([]byte(principal.Subject + ":" + string(body)))
+		}
+	}))
+}
+
+func TestHMACHandler(t *testing.T) {
+	handler := newHMACTestHandler()
+	signer := NewHMACSigner("client", []byte("secret"))
+
+	newRequest := func(body string) *http.Request {
+		req := httptest.NewRequest("POST", "/v1/users?page=2", strings.NewReader(body))
+		if !assert.NoError(t, signer.SignRequest(req)) {
+			t.FailNow()
+		}
+		return req
+	}
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// the body is restored for the handler
+	req := newRequest(`{"name":"alice"}`)
+	resp := serve(req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, `client:{"name":"alice"}`, resp.Body.String())
+
+	// the same nonce is rejected
+	req.Body = io.NopCloser(strings.NewReader(`{"name":"alice"}`))
+	resp = serve(req)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Equal(t, "replayed HMAC request\n", resp.Body.String())
+
+	// the body is tampered
+	req = newRequest(`{"name":"alice"}`)
+	req.Body = io.NopCloser(strings.NewReader(`{"name":"admin"}`))
+	assert.Equal(t, "invalid HMAC signature\n", serve(req).Body.String())
+
+	// the query is tampered
+	req = newRequest("")
+	req.URL.RawQuery = "page=3"
+	assert.Equal(t, "invalid HMAC signature\n", serve(req).Body.String())
+
+	req = newRequest("")
+	req.Header.Set(HMACKeyIDHeader, "other")
+	assert.Equal(t, "invalid HMAC key: unknown key \"other\"\n", serve(req).Body.String())
+
+	assert.Equal(t, "missing HMAC signature\n", serve(httptest.NewRequest("GET", "/v1/users", nil)).Body.String())
+
+	// the body of the unverified request is not read
+	body := &countingReader{Reader: strings.NewReader("body")}
+	req = httptest.NewRequest("POST", "/v1/users", body)
+	req.Header.Set(HMACKeyIDHeader, "other")
+	req.Header.Set(HMACTimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	req.Header.Set(HMACNonceHeader, "nonce")
+	req.Header.Set(HMACSignatureHeader, "signature")
+	assert.Equal(t, http.StatusUnauthorized, serve(req).Code)
+	assert.Equal(t, 0, body.n)
+
+	// the health checks are skipped
+	resp = serve(httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+// countingReader - count the bytes read from the reader
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestHMACMaxBodySize(t *testing.T) {
+	opts := NewHMACOpts(testHMACKeys)
+	opts.MaxBodySize = 8
+	handler := HMACHandler(opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	signer := NewHMACSigner("client", []byte("secret"))
+
+	serve := func(body string) int {
+		req := httptest.NewRequest("POST", "/v1/users", strings.NewReader(body))
+		signer.SignRequest(req)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("12345678"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve("123456789"))
+}
+
+func TestHMACWindow(t *testing.T) {
+	opts := NewHMACOpts(testHMACKeys)
+	now := time.Unix(1700000000, 0)
+	opts.now = func() time.Time { return now }
+
+	signer := NewHMACSigner("client", []byte("secret"))
+	verify := func(signedAt time.Time) error {
+		signer.now = func() time.Time { return signedAt }
+		req := httptest.NewRequest("GET", "/", nil)
+		signer.SignRequest(req)
+		_, err := opts.verify(context.Background(), req.Header.Get, req.Method, req.URL.RequestURI(), nil)
+		return err
+	}
+
+	assert.NoError(t, verify(now.Add(-opts.Window)))
+	assert.NoError(t, verify(now.Add(opts.Window)))
+	assert.Equal(t, status.Error(codes.Unauthenticated, "HMAC timestamp is out of the window"), verify(now.Add(-opts.Window-time.Second)))
+	assert.Equal(t, status.Error(codes.Unauthenticated, "HMAC timestamp is out of the window"), verify(now.Add(opts.Window+time.Second)))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(HMACKeyIDHeader, "client")
+	req.Header.Set(HMACTimestampHeader, "yesterday")
+	req.Header.Set(HMACNonceHeader, "nonce")
+	req.Header.Set(HMACSignatureHeader, "signature")
+	_, err := opts.verify(context.Background(), req.Header.Get, req.Method, req.URL.RequestURI(), nil)
+	assert.Equal(t, status.Error(codes.Unauthenticated, "invalid HMAC timestamp"), err)
+}
+
+func TestMemoryNonceStore(t *testing.T) {
+	store := NewMemoryNonceStore().(*memoryNonceStore)
+	now := time.Unix(1700000000, 0)
+	store.now = func() time.Time { return now }
+
+	assert.False(t, store.Seen(context.Background(), "a", now.Add(time.Minute)))
+	assert.True(t, store.Seen(context.Background(), "a", now.Add(time.Minute)))
+	assert.False(t, store.Seen(context.Background(), "b", now.Add(5*time.Minute)))
+
+	// the expired nonces are swept
+	now = now.Add(2 * time.Minute)
+	assert.False(t, store.Seen(context.Background(), "c", now.Add(time.Minute)))
+	assert.Len(t, store.nonces, 2)
+	assert.True(t, store.Seen(context.Background(), "b", now.Add(time.Minute)))
+}
+
+func TestHMACSignerTransport(t *testing.T) {
+	server := httptest.NewServer(newHMACTestHandler())
+	defer server.Close()
+
+	client := &http.Client{Transport: NewHMACSigner("client", []byte("secret")).Transport(nil)}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Post(server.URL+"/v1/users", "application/json", strings.NewReader("body"+strconv.Itoa(i)))
+		if !assert.NoError(t, err) {
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "client:body"+strconv.Itoa(i), string(body))
+	}
+}
+
+func TestHMACAuthentication(t *testing.T) {
+	opts := NewHMACOpts(testHMACKeys)
+	// the health service requires the signatures
+	opts.SkipMethods = nil
+	s := NewService(InProcessGateway(true), HMACAuthentication(opts))
+	addr := startInProcessService(t, s)
+
+	assert.NoError(t, checkHealth(t, addr, ClientHMAC(NewHMACSigner("client", []byte("secret")))))
+	assert.Equal(t, codes.Unauthenticated, status.Code(checkHealth(t, addr)))
+	assert.Equal(t, codes.Unauthenticated, status.Code(checkHealth(t, addr, ClientHMAC(NewHMACSigner("client", []byte("wrong"))))))
+
+	// the gateway re-signs the calls of the verified requests
+	req := httptest.NewRequest("GET", "/health", nil)
+	NewHMACSigner("client", []byte("secret")).SignRequest(req)
+	resp := httptest.NewRecorder()
+	s.gatewayHandler().ServeHTTP(resp, req)
+	assert.Equal(t, "SERVING", resp.Body.String())
+
+	resp = httptest.NewRecorder()
+	s.gatewayHandler().ServeHTTP(resp, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
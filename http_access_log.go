@@ -0,0 +1,233 @@
+package micro
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat - the format of the http access log lines
+type AccessLogFormat int
+
+const (
+	// AccessLogCombined - the Apache combined log format, followed by the latency and the quoted request id
+	AccessLogCombined AccessLogFormat = iota
+	// AccessLogJSON - one JSON object per line
+	AccessLogJSON
+)
+
+// AccessLogOpts - the options of the http access log
+type AccessLogOpts struct {
+	// the format of the lines written to Writer
+	Format AccessLogFormat
+	// the writer of the access log lines, if it is nil the fields are logged with the micro logger
+	Writer io.Writer
+	// the paths which will not be logged, e.g. /metrics
+	SkipPaths []string
+}
+
+// NewAccessLogOpts - create the http access log options which log with the micro logger and skip
+// the /metrics, /healthz and /readyz routes
+func NewAccessLogOpts() *AccessLogOpts {
+	return &AccessLogOpts{
+		Format:    AccessLogCombined,
+		SkipPaths: []string{"/metrics", "/healthz", "/readyz"},
+	}
+}
+
+// accessLogEntry - the fields of an access log line
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Query      string    `json:"query,omitempty"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Bytes      int       `json:"bytes"`
+	Latency    string    `json:"latency"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent"`
+	RequestID  string    `json:"request_id"`
+
+	requestURI string
+	user       string
+}
+
+// AccessLogHandler - return a http middleware which logs the requests according to the options
+func AccessLogHandler(opts *AccessLogOpts) func(http.Handler) http.Handler {
+	skip := make(map[string]bool, len(opts.SkipPaths))
+	for _, path := range opts.SkipPaths {
+		skip[path] = true
+	}
+
+	var mu sync.Mutex
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skip[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			recorder := newResponseRecorder(w)
+			next.ServeHTTP(recorder, r)
+
+			entry := newAccessLogEntry(r, recorder, start)
+
+			if opts.Writer == nil {
+				entry.log(GetLogger())
+				return
+			}
+
+			var line []byte
+			if opts.Format == AccessLogJSON {
+				line, _ = json.Marshal(entry)
+			} else {
+				line = []byte(entry.combined())
+			}
+			line = append(line, '\n')
+
+			mu.Lock()
+			opts.Writer.Write(line)
+			mu.Unlock()
+		})
+	}
+}
+
+func newAccessLogEntry(r *http.Request, recorder *responseRecorder, start time.Time) *accessLogEntry {
+	remoteAddr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteAddr = r.RemoteAddr
+	}
+
+	// the request id is set into the response header by InitSpan
+	requestID := recorder.Header().Get("X-Request-Id")
+	if requestID == "" {
+		requestID = r.Header.Get("X-Request-Id")
+	}
+
+	user := "-"
+	if r.URL.User != nil && r.URL.User.Username() != "" {
+		user = r.URL.User.Username()
+	}
+
+	// the sensitive query parameters are never written into the access log
+	policy := GetRedactionPolicy()
+
+	return &accessLogEntry{
+		Time:       start,
+		RemoteAddr: remoteAddr,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Query:      policy.Query(r.URL.RawQuery),
+		Proto:      r.Proto,
+		Status:     recorder.Status(),
+		Bytes:      recorder.Size(),
+		Latency:    time.Since(start).String(),
+		Referer:    r.Referer(),
+		UserAgent:  r.UserAgent(),
+		RequestID:  requestID,
+		requestURI: policy.RequestURI(r.URL),
+		user:       user,
+	}
+}
+
+// combined - format the entry in the Apache combined log format with the latency and request id
+func (e *accessLogEntry) combined() string {
+	return fmt.Sprintf(`%s - %s [%s] %s %d %d %s %s %s %s`,
+		e.RemoteAddr,
+		e.user,
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(strings.Join([]string{e.Method, e.requestURI, e.Proto}, " ")),
+		e.Status,
+		e.Bytes,
+		strconv.Quote(e.Referer),
+		strconv.Quote(e.UserAgent),
+		e.Latency,
+		strconv.Quote(e.RequestID),
+	)
+}
+
+// log - log the entry with the logger, the server errors are logged with the error level
+func (e *accessLogEntry) log(l StructuredLogger) {
+	level := LevelInfo
+	if e.Status >= http.StatusInternalServerError {
+		level = LevelError
+	}
+
+	logAt(l, level, "HTTP access",
+		"remote_addr", e.RemoteAddr,
+		"method", e.Method,
+		"path", e.Path,
+		"query", e.Query,
+		"proto", e.Proto,
+		"status", e.Status,
+		"bytes", e.Bytes,
+		"latency", e.Latency,
+		"referer", e.Referer,
+		"user_agent", e.UserAgent,
+		"request_id", e.RequestID,
+	)
+}
+
+// responseRecorder - the response writer which records the status code and the size of the body
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w}
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+
+	return n, err
+}
+
+// Flush - implements http.Flusher, which is required by the streaming responses of the gateway
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap - return the original response writer for http.ResponseController
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Status - the status code of the response, 200 if it is not written explicitly
+func (r *responseRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+
+	return r.status
+}
+
+// Size - the size of the response body in bytes
+func (r *responseRecorder) Size() int {
+	return r.size
+}
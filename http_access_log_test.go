@@ -0,0 +1,98 @@
+package micro
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func accessLogTestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "uuid")
+		if r.URL.Path == "/error" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte("Hello!"))
+	})
+}
+
+func newAccessLogTestRequest(path string) *http.Request {
+	req := httptest.NewRequest("GET", path, nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("Referer", "http://example.com/")
+	return req
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	var buf bytes.Buffer
+	opts := NewAccessLogOpts()
+	opts.Writer = &buf
+	handler := AccessLogHandler(opts)(accessLogTestHandler())
+
+	handler.ServeHTTP(httptest.NewRecorder(), newAccessLogTestRequest("/test?a=1"))
+	assert.Regexp(t,
+		`^10\.0\.0\.1 - - \[.+\] "GET /test\?a=1 HTTP/1\.1" 200 6 "http://example.com/" "test-agent" \S+ "uuid"\n$`,
+		buf.String())
+
+	// the noisy routes are skipped
+	buf.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), newAccessLogTestRequest("/metrics"))
+	assert.Empty(t, buf.String())
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	var buf bytes.Buffer
+	opts := NewAccessLogOpts()
+	opts.Writer = &buf
+	opts.Format = AccessLogJSON
+	handler := AccessLogHandler(opts)(accessLogTestHandler())
+
+	handler.ServeHTTP(httptest.NewRecorder(), newAccessLogTestRequest("/error?a=1"))
+	assert.True(t, strings.HasSuffix(buf.String(), "\n"))
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "10.0.0.1", entry["remote_addr"])
+	assert.Equal(t, "GET", entry["method"])
+	assert.Equal(t, "/error", entry["path"])
+	assert.Equal(t, "a=1", entry["query"])
+	assert.Equal(t, float64(500), entry["status"])
+	assert.Equal(t, float64(6), entry["bytes"])
+	assert.Equal(t, "test-agent", entry["user_agent"])
+	assert.Equal(t, "uuid", entry["request_id"])
+	assert.NotEmpty(t, entry["latency"])
+}
+
+func TestAccessLogLogger(t *testing.T) {
+	jl := &recordingJaegerLogger{}
+	defer SetStructuredLogger(GetLogger())
+	SetStructuredLogger(NewJaegerLogger(jl))
+
+	handler := AccessLogHandler(NewAccessLogOpts())(accessLogTestHandler())
+	handler.ServeHTTP(httptest.NewRecorder(), newAccessLogTestRequest("/test"))
+	handler.ServeHTTP(httptest.NewRecorder(), newAccessLogTestRequest("/error"))
+
+	assert.Len(t, jl.lines, 2)
+	assert.True(t, strings.HasPrefix(jl.lines[0], "INFO HTTP access remote_addr=10.0.0.1 method=GET path=/test"))
+	assert.Contains(t, jl.lines[0], "status=200 bytes=6")
+	assert.Contains(t, jl.lines[0], "request_id=uuid")
+	assert.True(t, strings.HasPrefix(jl.lines[1], "ERROR HTTP access"))
+}
+
+func TestResponseRecorder(t *testing.T) {
+	recorder := newResponseRecorder(httptest.NewRecorder())
+	assert.Equal(t, http.StatusOK, recorder.Status())
+
+	recorder.WriteHeader(http.StatusNotFound)
+	recorder.WriteHeader(http.StatusOK)
+	recorder.Write([]byte("404"))
+	recorder.Flush()
+	assert.Equal(t, http.StatusNotFound, recorder.Status())
+	assert.Equal(t, 3, recorder.Size())
+}
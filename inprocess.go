@@ -0,0 +1,95 @@
+package micro
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// the buffer size of the in-memory connections between the gateway and the gRPC server
+const inProcessBufferSize = 1 << 20
+
+// the dial target of the in-process gateway connection, which is ignored by the context dialer
+const inProcessTarget = "in-process"
+
+// inProcessListener - the in-memory listener of the gRPC server for the gateway, the accepted
+// connections are marked so that they skip the transport security
+type inProcessListener struct {
+	*bufconn.Listener
+}
+
+func newInProcessListener() *inProcessListener {
+	return &inProcessListener{bufconn.Listen(inProcessBufferSize)}
+}
+
+func (l *inProcessListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &inProcessConn{conn}, nil
+}
+
+// dialOptions - the dial options of the gateway connecting through the listener
+func (l *inProcessListener) dialOptions(opts []grpc.DialOption, skipSecurity bool) []grpc.DialOption {
+	opts = append(opts[:len(opts):len(opts)], grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return l.DialContext(ctx)
+	}))
+	if skipSecurity {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+
+	return opts
+}
+
+// inProcessConn - the connection accepted by inProcessListener
+type inProcessConn struct {
+	net.Conn
+}
+
+// inProcessCredentials - the transport credentials of the gRPC server which skip the handshake
+// of the in-process connections
+type inProcessCredentials struct {
+	credentials.TransportCredentials
+}
+
+func (c inProcessCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if _, ok := conn.(*inProcessConn); ok {
+		return insecure.NewCredentials().ServerHandshake(conn)
+	}
+
+	return c.TransportCredentials.ServerHandshake(conn)
+}
+
+func (c inProcessCredentials) Clone() credentials.TransportCredentials {
+	return inProcessCredentials{c.TransportCredentials.Clone()}
+}
+
+// serveInProcess - serve the in-process listener for the gateway if it is enabled, it must be
+// called after the services are registered
+func (s *Service) serveInProcess() {
+	if s.inProcessLis == nil {
+		return
+	}
+
+	go func() {
+		if err := s.GRPCServer.Serve(s.inProcessLis); err != nil {
+			GetLogger().Error("In-process gRPC server stopped", "error", err)
+		}
+	}()
+}
+
+// gatewayTarget - the target and the dial options of the gateway connecting to the gRPC server
+func (s *Service) gatewayTarget(grpcHostAndPort string) (string, []grpc.DialOption) {
+	dialOptions := append(append([]grpc.DialOption{}, s.grpcDialOptions...), s.gatewayDialOptions...)
+	if s.inProcessLis == nil {
+		return grpcHostAndPort, dialOptions
+	}
+
+	return inProcessTarget, s.inProcessLis.dialOptions(dialOptions, s.grpcCredentials != nil)
+}
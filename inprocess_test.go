@@ -0,0 +1,107 @@
+package micro
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// newTestTLSConfig - create a TLS config with a self-signed certificate of localhost
+func newTestTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+// startInProcessService - serve the gRPC server of the service on a TCP port and init the gateway,
+// the gateway is given an unreachable gRPC address so that it can only connect in-process
+func startInProcessService(t *testing.T, s *Service) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.startGRPCServer(lis)
+	t.Cleanup(s.GRPCServer.Stop)
+
+	if err := s.initGateway("127.0.0.1:1", healthReverseProxyFunc("health")); err != nil {
+		t.Fatal(err)
+	}
+
+	return lis.Addr().String()
+}
+
+func checkHealth(t *testing.T, addr string, opts ...ClientOption) error {
+	conn, err := Dial(addr, append(opts, ClientTimeout(time.Second))...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	return err
+}
+
+func TestInProcessGateway(t *testing.T) {
+	s := NewService(InProcessGateway(true))
+	addr := startInProcessService(t, s)
+
+	resp := httptest.NewRecorder()
+	s.gatewayHandler().ServeHTTP(resp, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, "SERVING", resp.Body.String())
+
+	// the TCP port is still served for the other clients
+	assert.NoError(t, checkHealth(t, addr))
+}
+
+func TestInProcessGatewayDisabled(t *testing.T) {
+	s := NewService()
+	startInProcessService(t, s)
+
+	resp := httptest.NewRecorder()
+	s.gatewayHandler().ServeHTTP(resp, httptest.NewRequest("GET", "/health", nil))
+	assert.NotEqual(t, "SERVING", resp.Body.String())
+}
+
+func TestInProcessGatewayCredentials(t *testing.T) {
+	s := NewService(
+		InProcessGateway(true),
+		GRPCCredentials(credentials.NewTLS(newTestTLSConfig(t))),
+	)
+	addr := startInProcessService(t, s)
+
+	// the in-process connection skips the TLS handshake
+	resp := httptest.NewRecorder()
+	s.gatewayHandler().ServeHTTP(resp, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, "SERVING", resp.Body.String())
+
+	// the TCP port requires TLS
+	assert.NoError(t, checkHealth(t, addr, ClientTLS(&tls.Config{InsecureSkipVerify: true})))
+	assert.Error(t, checkHealth(t, addr))
+}
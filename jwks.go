@@ -0,0 +1,329 @@
+package micro
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// KeySet - the keys to verify the signatures of the JWTs
+type KeySet interface {
+	// Key - get the key of the key id for the algorithm, the key id is empty if the token has no
+	// "kid" header. It is a *rsa.PublicKey for RS256, a *ecdsa.PublicKey for ES256 and a []byte for HS256
+	Key(ctx context.Context, kid string, alg string) (interface{}, error)
+}
+
+// NewHMACKeySet - create the key set of the HS256 shared secret
+func NewHMACKeySet(secret []byte) KeySet {
+	return hmacKeySet(secret)
+}
+
+type hmacKeySet []byte
+
+func (s hmacKeySet) Key(ctx context.Context, kid string, alg string) (interface{}, error) {
+	if alg != JWTHS256 {
+		return nil, fmt.Errorf("no key for algorithm %s", alg)
+	}
+
+	return []byte(s), nil
+}
+
+// JWKS - the key set loaded from a JSON Web Key Set document, the keys are cached and reloaded
+// when they expire or when a token is signed by an unknown key id, e.g. after the keys are rotated.
+// The reloads are shared by the concurrent callers and the expired keys are served while reloading
+type JWKS struct {
+	// how long the keys are cached
+	CacheTTL time.Duration
+	// the minimum interval between the reloads, e.g. for the unknown key ids, to bound the load of
+	// the tokens with forged key ids and the retries of the failures
+	MinRefreshInterval time.Duration
+	// the http client to fetch the JWKS url, its timeout bounds the reloads
+	Client *http.Client
+
+	fetch func(ctx context.Context) ([]byte, error)
+	now   func() time.Time
+
+	mu         sync.Mutex
+	keys       []*jwk
+	fetched    time.Time
+	attempted  time.Time
+	refreshing chan struct{}
+}
+
+var _ KeySet = (*JWKS)(nil)
+
+// NewJWKSFile - create the key set loaded from the JWKS file, the keys are cached for 1 minute
+func NewJWKSFile(path string) *JWKS {
+	return newJWKS(time.Minute, func(ctx context.Context) ([]byte, error) {
+		return os.ReadFile(path)
+	})
+}
+
+// NewJWKSURL - create the key set loaded from the JWKS url, e.g. the jwks_uri of the OpenID
+// provider, the keys are cached for 10 minutes and fetched with a timeout of 10 seconds
+func NewJWKSURL(url string) *JWKS {
+	var s *JWKS
+	s = newJWKS(10*time.Minute, func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := s.Client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+
+		return io.ReadAll(resp.Body)
+	})
+	s.Client = &http.Client{Timeout: 10 * time.Second}
+
+	return s
+}
+
+func newJWKS(ttl time.Duration, fetch func(ctx context.Context) ([]byte, error)) *JWKS {
+	return &JWKS{
+		CacheTTL:           ttl,
+		MinRefreshInterval: 10 * time.Second,
+		fetch:              fetch,
+		now:                time.Now,
+	}
+}
+
+// Key - implements KeySet, the token without key id matches the only key of the algorithm
+func (s *JWKS) Key(ctx context.Context, kid string, alg string) (interface{}, error) {
+	now := s.now()
+
+	s.mu.Lock()
+	loaded := s.keys != nil
+	expired := !loaded || now.Sub(s.fetched) >= s.CacheTTL
+	s.mu.Unlock()
+
+	// only the first load is waited, the expired keys are served while reloading
+	if expired {
+		s.refresh(ctx, now, !loaded)
+	}
+
+	key, err := s.find(kid, alg)
+	if err != nil && s.refresh(ctx, now, true) {
+		key, err = s.find(kid, alg)
+	}
+
+	return key, err
+}
+
+// refresh - start the reload at most once per MinRefreshInterval or join the running one, and wait
+// for it to finish if required until the context is done. It returns true if the reload is waited
+func (s *JWKS) refresh(ctx context.Context, now time.Time, wait bool) bool {
+	s.mu.Lock()
+	done := s.refreshing
+	if done == nil {
+		if !s.attempted.IsZero() && now.Sub(s.attempted) < s.MinRefreshInterval {
+			s.mu.Unlock()
+			return false
+		}
+		s.attempted = now
+
+		done = make(chan struct{})
+		s.refreshing = done
+		go s.reload(done, now)
+	}
+	s.mu.Unlock()
+
+	if !wait {
+		return false
+	}
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// reload - load the keys without the lock, the cached keys are kept if it fails. It is not bound to
+// the context of the caller which starts it as the other callers may be waiting for it
+func (s *JWKS) reload(done chan struct{}, now time.Time) {
+	keys, err := s.load(context.Background())
+
+	s.mu.Lock()
+	if err != nil {
+		GetLogger().Warn("Failed to load the JWKS", "error", err)
+	} else {
+		s.keys = keys
+		s.fetched = now
+	}
+	s.refreshing = nil
+	s.mu.Unlock()
+
+	close(done)
+}
+
+func (s *JWKS) load(ctx context.Context) ([]*jwk, error) {
+	data, err := s.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []*jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make([]*jwk, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		// skip the keys for encryption and the unsupported ones, e.g. the new key types
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if err := k.parse(); err != nil {
+			GetLogger().Warn("Skipping the invalid JWK", "kid", k.Kid, "error", err)
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+func (s *JWKS) find(kid string, alg string) (interface{}, error) {
+	s.mu.Lock()
+	keys := s.keys
+	s.mu.Unlock()
+
+	if keys == nil {
+		return nil, errors.New("the JWKS is not loaded")
+	}
+
+	var found *jwk
+	for _, k := range keys {
+		if (k.Kid != kid && kid != "") || !k.supports(alg) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("multiple keys for algorithm %s", alg)
+		}
+		found = k
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("no key %q for algorithm %s", kid, alg)
+	}
+
+	return found.key, nil
+}
+
+// jwk - the JSON Web Key of RSA, EC P-256 or the symmetric key
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// symmetric
+	K string `json:"k"`
+
+	key interface{}
+}
+
+func (k *jwk) parse() error {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return errors.New("invalid RSA exponent")
+		}
+		k.key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return errors.New("invalid EC point")
+		}
+		k.key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return err
+		}
+		k.key = secret
+
+	default:
+		return fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+
+	return nil
+}
+
+// supports - check if the key can verify the algorithm
+func (k *jwk) supports(alg string) bool {
+	if k.Alg != "" && k.Alg != alg {
+		return false
+	}
+
+	switch alg {
+	case JWTRS256:
+		return k.Kty == "RSA"
+	case JWTES256:
+		return k.Kty == "EC"
+	case JWTHS256:
+		return k.Kty == "oct"
+	}
+
+	return false
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty integer")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
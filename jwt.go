@@ -0,0 +1,318 @@
+package micro
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// the supported JWT signing algorithms
+const (
+	JWTRS256 = "RS256"
+	JWTES256 = "ES256"
+	JWTHS256 = "HS256"
+)
+
+// JWTOpts - the options of the JWT verification
+type JWTOpts struct {
+	// the keys to verify the signatures, see NewJWKSFile, NewJWKSURL and NewHMACKeySet
+	KeySet KeySet
+	// the accepted signing algorithms
+	Algorithms []string
+	// the expected "iss" claim, it is not checked if empty
+	Issuer string
+	// the expected audience which one of the "aud" claim has to match, it is not checked if empty
+	Audience string
+	// the tolerance of the clock difference in the checks of the "exp", "nbf" and "iat" claims
+	ClockSkew time.Duration
+	// whether the "exp" claim is required, otherwise the tokens without it never expire
+	RequireExpiration bool
+	// the cookie carrying the token when there is no Authorization header, e.g. for the browsers
+	Cookie string
+
+	now func() time.Time
+}
+
+// NewJWTOpts - create the JWT options which accept RS256, ES256 and HS256 with 1 minute clock skew
+// and require the "exp" claim
+func NewJWTOpts(keySet KeySet) *JWTOpts {
+	return &JWTOpts{
+		KeySet:            keySet,
+		Algorithms:        []string{JWTRS256, JWTES256, JWTHS256},
+		ClockSkew:         time.Minute,
+		RequireExpiration: true,
+	}
+}
+
+// NewJWTAuthenticator - create the Authenticator which verifies the bearer token in the
+// Authorization header, or in the cookie if it is set, the principal has the "sub" claim as the
+// subject, the "roles" claim as the roles, the "scope" or "scp" claim as the scopes and all the
+// claims, which can be retrieved with JWTClaimsFromContext
+func NewJWTAuthenticator(opts *JWTOpts) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, md metadata.MD) (*Principal, error) {
+		token := opts.token(md)
+		if token == "" {
+			return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+		}
+
+		claims, err := opts.Verify(ctx, token)
+		if err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+		}
+
+		subject, _ := claims["sub"].(string)
+		scopes := claimStrings(claims["scope"])
+		if len(scopes) == 0 {
+			scopes = claimStrings(claims["scp"])
+		}
+
+		return &Principal{
+			Subject: subject,
+			Roles:   claimStrings(claims["roles"]),
+			Scopes:  scopes,
+			Claims:  claims,
+		}, nil
+	})
+}
+
+// NewJWTAnnotator - create the AnnotatorFunc which forwards the token in the cookie of the options
+// as the Authorization bearer token to the gRPC server, the Authorization header is forwarded by
+// the gateway already and takes precedence
+func NewJWTAnnotator(opts *JWTOpts) AnnotatorFunc {
+	return func(ctx context.Context, req *http.Request) metadata.MD {
+		if opts.Cookie == "" || req.Header.Get("Authorization") != "" {
+			return nil
+		}
+
+		cookie, err := req.Cookie(opts.Cookie)
+		if err != nil || cookie.Value == "" {
+			return nil
+		}
+
+		return metadata.Pairs("authorization", "Bearer "+cookie.Value)
+	}
+}
+
+// JWTClaimsFromContext - get the claims of the caller authenticated by the JWT authenticator
+func JWTClaimsFromContext(ctx context.Context) (map[string]interface{}, bool) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.Claims == nil {
+		return nil, false
+	}
+
+	return principal.Claims, true
+}
+
+// claimStrings - get the strings of the claim which is either a list or a space separated string
+func claimStrings(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+
+	return nil
+}
+
+// token - get the bearer token from the Authorization header or the cookie
+func (opts *JWTOpts) token(md metadata.MD) string {
+	for _, auth := range md.Get("authorization") {
+		if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+			return strings.TrimSpace(auth[7:])
+		}
+	}
+
+	if opts.Cookie != "" {
+		req := http.Request{Header: http.Header{"Cookie": md.Get("cookie")}}
+		if cookie, err := req.Cookie(opts.Cookie); err == nil {
+			return cookie.Value
+		}
+	}
+
+	return ""
+}
+
+// Verify - verify the signature and the claims of the compact serialized JWT and return the claims
+func (opts *JWTOpts) Verify(ctx context.Context, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %v", err)
+	}
+	if !opts.accepts(header.Alg) {
+		return nil, fmt.Errorf("unexpected algorithm %q", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %v", err)
+	}
+
+	key, err := opts.KeySet.Key(ctx, header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %v", err)
+	}
+	if err := opts.validate(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// accepts - check if the algorithm is accepted, they are case sensitive so "none" is never accepted
+func (opts *JWTOpts) accepts(alg string) bool {
+	for _, a := range opts.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validate - check the time, the issuer and the audience claims
+func (opts *JWTOpts) validate(claims map[string]interface{}) error {
+	now := time.Now()
+	if opts.now != nil {
+		now = opts.now()
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok && opts.RequireExpiration {
+		return errors.New("token has no expiration")
+	}
+	if ok && now.After(jwtTime(exp).Add(opts.ClockSkew)) {
+		return errors.New("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(opts.ClockSkew).Before(jwtTime(nbf)) {
+		return errors.New("token is not valid yet")
+	}
+	if iat, ok := claims["iat"].(float64); ok && now.Add(opts.ClockSkew).Before(jwtTime(iat)) {
+		return errors.New("token is issued in the future")
+	}
+
+	if opts.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != opts.Issuer {
+			return fmt.Errorf("unexpected issuer %q", iss)
+		}
+	}
+
+	if opts.Audience != "" {
+		var audiences []interface{}
+		switch aud := claims["aud"].(type) {
+		case string:
+			audiences = []interface{}{aud}
+		case []interface{}:
+			audiences = aud
+		}
+
+		matched := false
+		for _, aud := range audiences {
+			if aud == opts.Audience {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return errors.New("unexpected audience")
+		}
+	}
+
+	return nil
+}
+
+func jwtTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// verifyJWTSignature - verify the signature of the signing input with the key of the algorithm
+func verifyJWTSignature(alg string, key interface{}, signingInput string, signature []byte) error {
+	hash := sha256.Sum256([]byte(signingInput))
+
+	switch alg {
+	case JWTRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("invalid key type %T for %s", key, alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], signature); err != nil {
+			return errors.New("invalid signature")
+		}
+
+	case JWTES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("invalid key type %T for %s", key, alg)
+		}
+		if len(signature) != 64 {
+			return errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, hash[:], r, s) {
+			return errors.New("invalid signature")
+		}
+
+	case JWTHS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("invalid key type %T for %s", key, alg)
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("invalid signature")
+		}
+
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	return nil
+}
@@ -0,0 +1,358 @@
+package micro
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	testRSAKey, _   = rsa.GenerateKey(rand.Reader, 2048)
+	testECDSAKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testHMACSecret  = []byte("secret")
+)
+
+// signJWT - sign the claims with the key of the algorithm, the token expires in an hour unless the
+// "exp" claim is given, a nil "exp" is left out
+func signJWT(t *testing.T, alg string, kid string, claims map[string]interface{}) string {
+	all := map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()}
+	for k, v := range claims {
+		all[k] = v
+	}
+	if all["exp"] == nil {
+		delete(all, "exp")
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(all)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(input))
+
+	var signature []byte
+	switch alg {
+	case JWTRS256:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, testRSAKey, crypto.SHA256, hash[:])
+	case JWTES256:
+		r, s, err := ecdsa.Sign(rand.Reader, testECDSAKey, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	case JWTHS256:
+		mac := hmac.New(sha256.New, testHMACSecret)
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// testJWKS - the JWKS document of the test keys with the key id prefix
+func testJWKS(prefix string) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": prefix + "rsa", "use": "sig", "n": encodeBigInt(testRSAKey.N), "e": encodeBigInt(big.NewInt(int64(testRSAKey.E)))},
+			{"kty": "EC", "kid": prefix + "ec", "crv": "P-256", "x": encodeBigInt(testECDSAKey.X), "y": encodeBigInt(testECDSAKey.Y)},
+			{"kty": "oct", "kid": prefix + "hmac", "alg": "HS256", "k": base64.RawURLEncoding.EncodeToString(testHMACSecret)},
+			{"kty": "RSA", "kid": prefix + "enc", "use": "enc", "n": encodeBigInt(testRSAKey.N), "e": "AQAB"},
+			{"kty": "OKP", "kid": prefix + "ed", "crv": "Ed25519", "x": "AAAA"},
+		},
+	})
+
+	return data
+}
+
+// waitJWKSReload - wait for the background reload of the key set when the test finishes, so that
+// it does not outlive the test
+func waitJWKSReload(t *testing.T, jwks *JWKS) {
+	t.Cleanup(func() {
+		jwks.mu.Lock()
+		done := jwks.refreshing
+		jwks.mu.Unlock()
+
+		if done != nil {
+			<-done
+		}
+	})
+}
+
+func TestJWTVerify(t *testing.T) {
+	jwks := newJWKS(time.Minute, func(ctx context.Context) ([]byte, error) {
+		return testJWKS(""), nil
+	})
+	opts := NewJWTOpts(jwks)
+	opts.Issuer = "https://issuer"
+	opts.Audience = "api"
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"sub": "alice",
+		"iss": "https://issuer",
+		"aud": []string{"web", "api"},
+		"exp": now.Add(time.Hour).Unix(),
+		"iat": now.Unix(),
+	}
+
+	for _, c := range []struct{ alg, kid string }{{JWTRS256, "rsa"}, {JWTES256, "ec"}, {JWTHS256, "hmac"}, {JWTRS256, ""}} {
+		verified, err := opts.Verify(context.Background(), signJWT(t, c.alg, c.kid, claims))
+		if assert.NoError(t, err, c.alg) {
+			assert.Equal(t, "alice", verified["sub"])
+		}
+	}
+
+	// the tampered token
+	token := signJWT(t, JWTRS256, "rsa", claims)
+	tampered := signJWT(t, JWTRS256, "rsa", map[string]interface{}{"sub": "mallory"})
+	_, err := opts.Verify(context.Background(), token[:len(token)-20]+tampered[len(tampered)-20:])
+	assert.EqualError(t, err, "invalid signature")
+
+	// the key of the other key type
+	_, err = opts.Verify(context.Background(), signJWT(t, JWTHS256, "rsa", claims))
+	assert.EqualError(t, err, `no key "rsa" for algorithm HS256`)
+
+	// the encryption keys are skipped
+	_, err = opts.Verify(context.Background(), signJWT(t, JWTRS256, "enc", claims))
+	assert.EqualError(t, err, `no key "enc" for algorithm RS256`)
+
+	_, err = opts.Verify(context.Background(), "header.claims")
+	assert.EqualError(t, err, "malformed token")
+
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	_, err = opts.Verify(context.Background(), none+"."+none+".")
+	assert.EqualError(t, err, `unexpected algorithm "none"`)
+
+	opts.Algorithms = []string{JWTES256}
+	_, err = opts.Verify(context.Background(), signJWT(t, JWTRS256, "rsa", claims))
+	assert.EqualError(t, err, `unexpected algorithm "RS256"`)
+}
+
+func TestJWTClaims(t *testing.T) {
+	opts := NewJWTOpts(NewHMACKeySet(testHMACSecret))
+	opts.Issuer = "https://issuer"
+	opts.Audience = "api"
+	opts.ClockSkew = time.Minute
+	now := time.Unix(1700000000, 0)
+	opts.now = func() time.Time { return now }
+
+	verify := func(claims map[string]interface{}) error {
+		all := map[string]interface{}{"iss": "https://issuer", "aud": "api"}
+		for k, v := range claims {
+			all[k] = v
+		}
+		_, err := opts.Verify(context.Background(), signJWT(t, JWTHS256, "", all))
+		return err
+	}
+
+	assert.NoError(t, verify(nil))
+	// within the clock skew
+	assert.NoError(t, verify(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()}))
+	assert.NoError(t, verify(map[string]interface{}{"nbf": now.Add(30 * time.Second).Unix()}))
+	assert.EqualError(t, verify(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()}), "token is expired")
+	assert.EqualError(t, verify(map[string]interface{}{"exp": nil}), "token has no expiration")
+	assert.EqualError(t, verify(map[string]interface{}{"nbf": now.Add(2 * time.Minute).Unix()}), "token is not valid yet")
+	assert.EqualError(t, verify(map[string]interface{}{"iat": now.Add(2 * time.Minute).Unix()}), "token is issued in the future")
+	assert.EqualError(t, verify(map[string]interface{}{"iss": "https://other"}), `unexpected issuer "https://other"`)
+	assert.EqualError(t, verify(map[string]interface{}{"aud": []string{"web"}}), "unexpected audience")
+	assert.EqualError(t, verify(map[string]interface{}{"aud": nil}), "unexpected audience")
+
+	// the tokens without the expiration are accepted if it is not required
+	opts.RequireExpiration = false
+	assert.NoError(t, verify(map[string]interface{}{"exp": nil}))
+}
+
+func TestJWKSRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, testJWKS("v1-"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := NewJWKSFile(path)
+	waitJWKSReload(t, jwks)
+	now := time.Now()
+	jwks.now = func() time.Time { return now }
+	opts := NewJWTOpts(jwks)
+
+	_, err := opts.Verify(context.Background(), signJWT(t, JWTES256, "v1-ec", nil))
+	assert.NoError(t, err)
+
+	// the keys are rotated
+	if err := os.WriteFile(path, testJWKS("v2-"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the unknown key id reloads the keys after the min refresh interval
+	_, err = opts.Verify(context.Background(), signJWT(t, JWTES256, "v2-ec", nil))
+	assert.Error(t, err)
+	now = now.Add(jwks.MinRefreshInterval)
+	_, err = opts.Verify(context.Background(), signJWT(t, JWTES256, "v2-ec", nil))
+	assert.NoError(t, err)
+	_, err = opts.Verify(context.Background(), signJWT(t, JWTES256, "v1-ec", nil))
+	assert.Error(t, err)
+
+	// the cached keys are kept if the reload fails
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(jwks.CacheTTL)
+	_, err = opts.Verify(context.Background(), signJWT(t, JWTES256, "v2-ec", nil))
+	assert.NoError(t, err)
+}
+
+func TestJWKSURL(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write(testJWKS(""))
+	}))
+	defer server.Close()
+
+	opts := NewJWTOpts(NewJWKSURL(server.URL))
+	for i := 0; i < 3; i++ {
+		_, err := opts.Verify(context.Background(), signJWT(t, JWTRS256, "rsa", nil))
+		assert.NoError(t, err)
+	}
+	// the keys are cached
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// the unknown key ids do not reload within the min refresh interval
+	_, err := opts.Verify(context.Background(), signJWT(t, JWTRS256, "forged", nil))
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestJWKSURLHung(t *testing.T) {
+	var requests int32
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// only the first request is served, the others hang until the test finishes
+		if atomic.AddInt32(&requests, 1) > 1 {
+			<-unblock
+		}
+		w.Write(testJWKS(""))
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	jwks := NewJWKSURL(server.URL)
+	waitJWKSReload(t, jwks)
+	now := time.Now()
+	jwks.now = func() time.Time { return now }
+	opts := NewJWTOpts(jwks)
+
+	_, err := opts.Verify(context.Background(), signJWT(t, JWTRS256, "rsa", nil))
+	assert.NoError(t, err)
+
+	// the expired keys are served while reloading
+	now = now.Add(jwks.CacheTTL)
+	start := time.Now()
+	_, err = opts.Verify(context.Background(), signJWT(t, JWTRS256, "rsa", nil))
+	assert.NoError(t, err)
+
+	// the callers waiting for the reload are bounded by their contexts
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = opts.Verify(ctx, signJWT(t, JWTRS256, "unknown", nil))
+	assert.Error(t, err)
+	assert.WithinDuration(t, start, time.Now(), 500*time.Millisecond)
+	// the reload is shared
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestJWKSURLTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	jwks := NewJWKSURL(server.URL)
+	waitJWKSReload(t, jwks)
+	jwks.Client = &http.Client{Timeout: 50 * time.Millisecond}
+
+	start := time.Now()
+	_, err := NewJWTOpts(jwks).Verify(context.Background(), signJWT(t, JWTRS256, "rsa", nil))
+	assert.EqualError(t, err, "the JWKS is not loaded")
+	assert.WithinDuration(t, start, time.Now(), 500*time.Millisecond)
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	opts := NewJWTOpts(NewHMACKeySet(testHMACSecret))
+	opts.Cookie = "session"
+	authenticator := NewJWTAuthenticator(opts)
+	token := signJWT(t, JWTHS256, "", map[string]interface{}{"sub": "alice", "scope": "read write", "roles": []string{"admin"}})
+
+	principal, err := authenticator.Authenticate(context.Background(), metadata.Pairs("authorization", "bearer "+token))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "alice", principal.Subject)
+		assert.Equal(t, []string{"admin"}, principal.Roles)
+		assert.Equal(t, []string{"read", "write"}, principal.Scopes)
+		assert.Equal(t, "read write", principal.Claims["scope"])
+
+		claims, ok := JWTClaimsFromContext(contextWithPrincipal(context.Background(), principal))
+		assert.True(t, ok)
+		assert.Equal(t, "read write", claims["scope"])
+	}
+
+	principal, err = authenticator.Authenticate(context.Background(), metadata.Pairs("authorization", "Bearer "+signJWT(t, JWTHS256, "", map[string]interface{}{"scp": []string{"read"}})))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"read"}, principal.Scopes)
+		assert.Nil(t, principal.Roles)
+	}
+
+	principal, err = authenticator.Authenticate(context.Background(), metadata.Pairs("cookie", "lang=en; session="+token))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "alice", principal.Subject)
+	}
+
+	_, err = authenticator.Authenticate(context.Background(), metadata.Pairs("authorization", "Basic dXNlcjpwYXNz"))
+	assert.Equal(t, status.Error(codes.Unauthenticated, "missing bearer token"), err)
+
+	_, err = authenticator.Authenticate(context.Background(), metadata.Pairs("authorization", "Bearer "+token+"x"))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, ok := JWTClaimsFromContext(context.Background())
+	assert.False(t, ok)
+}
+
+func TestJWTAnnotator(t *testing.T) {
+	opts := NewJWTOpts(NewHMACKeySet(testHMACSecret))
+	annotator := NewJWTAnnotator(opts)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "token"})
+	assert.Nil(t, annotator(context.Background(), req))
+
+	opts.Cookie = "session"
+	assert.Equal(t, metadata.Pairs("authorization", "Bearer token"), annotator(context.Background(), req))
+
+	// the Authorization header is forwarded by the gateway
+	req.Header.Set("Authorization", "Bearer header")
+	assert.Nil(t, annotator(context.Background(), req))
+}
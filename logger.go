@@ -1,19 +1,140 @@
 package micro
 
-import jaeger "github.com/uber/jaeger-client-go"
+import (
+	"context"
+	"fmt"
+	"strings"
 
-var logger jaeger.Logger
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// StructuredLogger - the structured and leveled logger, the keyvals are alternating keys and values,
+// e.g. Info("Starting http server", "addr", ":8888")
+type StructuredLogger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+	// With - return a logger which always logs with the given keyvals
+	With(keyvals ...interface{}) StructuredLogger
+}
+
+var logger StructuredLogger
 
 func init() {
-	logger = jaeger.NullLogger
+	logger = NopLogger
 }
 
-// SetLogger - set the logger
-func SetLogger(l jaeger.Logger) {
+// SetStructuredLogger - set the logger
+func SetStructuredLogger(l StructuredLogger) {
 	logger = l
 }
 
-// Logger - get the logger
-func Logger() jaeger.Logger {
+// GetLogger - get the logger
+func GetLogger() StructuredLogger {
 	return logger
 }
+
+// SetLogger - set the jaeger logger, see NewJaegerLogger
+//
+// Deprecated: use SetStructuredLogger with NewSlogLogger, NewZapLogger or NewJaegerLogger instead
+func SetLogger(l jaeger.Logger) {
+	SetStructuredLogger(NewJaegerLogger(l))
+}
+
+// Logger - get the logger as a jaeger logger, the one set by SetLogger is returned as it is
+//
+// Deprecated: use GetLogger instead
+func Logger() jaeger.Logger {
+	if j, ok := GetLogger().(*jaegerLogger); ok && len(j.keyvals) == 0 {
+		return j.l
+	}
+
+	return structuredJaegerLogger{l: GetLogger()}
+}
+
+// structuredJaegerLogger - the jaeger logger which writes to the structured logger
+type structuredJaegerLogger struct {
+	l StructuredLogger
+}
+
+func (s structuredJaegerLogger) Error(msg string) {
+	s.l.Error(msg)
+}
+
+func (s structuredJaegerLogger) Infof(msg string, args ...interface{}) {
+	s.l.Info(fmt.Sprintf(msg, args...))
+}
+
+// LogLevel - the level of the log, the zero value is LevelInfo
+type LogLevel int
+
+// the log levels
+const (
+	LevelDebug LogLevel = -4
+	LevelInfo  LogLevel = 0
+	LevelWarn  LogLevel = 4
+	LevelError LogLevel = 8
+)
+
+// logAt - log with the given level
+func logAt(l StructuredLogger, level LogLevel, msg string, keyvals ...interface{}) {
+	switch {
+	case level >= LevelError:
+		l.Error(msg, keyvals...)
+	case level >= LevelWarn:
+		l.Warn(msg, keyvals...)
+	case level >= LevelInfo:
+		l.Info(msg, keyvals...)
+	default:
+		l.Debug(msg, keyvals...)
+	}
+}
+
+type loggerKey struct{}
+
+// ContextWithLogger - return a copy of the context which carries the logger
+func ContextWithLogger(ctx context.Context, l StructuredLogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// LoggerFromContext - get the logger carried by the context, which is pre-populated with the
+// request_id, trace_id, span_id and method of the request, the global logger is returned if the
+// context carries no logger
+func LoggerFromContext(ctx context.Context) StructuredLogger {
+	if l, ok := ctx.Value(loggerKey{}).(StructuredLogger); ok {
+		return l
+	}
+
+	return GetLogger()
+}
+
+// NopLogger - the logger which discards everything, it is the default logger
+var NopLogger StructuredLogger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, keyvals ...interface{})       {}
+func (nopLogger) Info(msg string, keyvals ...interface{})        {}
+func (nopLogger) Warn(msg string, keyvals ...interface{})        {}
+func (nopLogger) Error(msg string, keyvals ...interface{})       {}
+func (l nopLogger) With(keyvals ...interface{}) StructuredLogger { return l }
+
+// formatKeyvals - format the keyvals as "key1=value1 key2=value2", a missing value is shown as "!MISSING"
+func formatKeyvals(keyvals ...interface{}) string {
+	var b strings.Builder
+
+	for i := 0; i < len(keyvals); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+
+		var v interface{} = "!MISSING"
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+		fmt.Fprintf(&b, "%v=%v", keyvals[i], v)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,57 @@
+package micro
+
+import jaeger "github.com/uber/jaeger-client-go"
+
+type jaegerLogger struct {
+	l       jaeger.Logger
+	keyvals []interface{}
+}
+
+// jaegerDebugLogger - the jaeger logger which also logs in debug level, e.g. jaeger.DebugLogger of
+// the newer jaeger clients
+type jaegerDebugLogger interface {
+	jaeger.Logger
+	Debugf(msg string, args ...interface{})
+}
+
+// NewJaegerLogger - create a StructuredLogger which writes to the jaeger logger, the keyvals are
+// appended to the message since the jaeger logger is not structured. The debug level is logged with
+// Debugf if the jaeger logger has it and discarded otherwise, the other levels except error are
+// logged with Infof
+func NewJaegerLogger(l jaeger.Logger) StructuredLogger {
+	return &jaegerLogger{l: l}
+}
+
+func (j *jaegerLogger) Debug(msg string, keyvals ...interface{}) {
+	if d, ok := j.l.(jaegerDebugLogger); ok {
+		d.Debugf("DEBUG %s", j.format(msg, keyvals))
+	}
+}
+
+func (j *jaegerLogger) Info(msg string, keyvals ...interface{}) {
+	j.l.Infof("INFO %s", j.format(msg, keyvals))
+}
+
+func (j *jaegerLogger) Warn(msg string, keyvals ...interface{}) {
+	j.l.Infof("WARN %s", j.format(msg, keyvals))
+}
+
+func (j *jaegerLogger) Error(msg string, keyvals ...interface{}) {
+	j.l.Error(j.format(msg, keyvals))
+}
+
+func (j *jaegerLogger) With(keyvals ...interface{}) StructuredLogger {
+	return &jaegerLogger{
+		l:       j.l,
+		keyvals: append(append([]interface{}{}, j.keyvals...), keyvals...),
+	}
+}
+
+func (j *jaegerLogger) format(msg string, keyvals []interface{}) string {
+	keyvals = append(append([]interface{}{}, j.keyvals...), keyvals...)
+	if len(keyvals) == 0 {
+		return msg
+	}
+
+	return msg + " " + formatKeyvals(keyvals...)
+}
@@ -0,0 +1,35 @@
+package micro
+
+import (
+	"context"
+	"log/slog"
+)
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger - create a StructuredLogger which writes to the slog logger
+func NewSlogLogger(l *slog.Logger) StructuredLogger {
+	return &slogLogger{l: l}
+}
+
+func (s *slogLogger) Debug(msg string, keyvals ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelDebug, msg, keyvals...)
+}
+
+func (s *slogLogger) Info(msg string, keyvals ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelInfo, msg, keyvals...)
+}
+
+func (s *slogLogger) Warn(msg string, keyvals ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelWarn, msg, keyvals...)
+}
+
+func (s *slogLogger) Error(msg string, keyvals ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelError, msg, keyvals...)
+}
+
+func (s *slogLogger) With(keyvals ...interface{}) StructuredLogger {
+	return &slogLogger{l: s.l.With(keyvals...)}
+}
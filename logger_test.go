@@ -0,0 +1,143 @@
+package micro
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	jaeger "github.com/uber/jaeger-client-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type recordingJaegerLogger struct {
+	lines []string
+}
+
+func (l *recordingJaegerLogger) Error(msg string) {
+	l.lines = append(l.lines, "ERROR "+msg)
+}
+
+func (l *recordingJaegerLogger) Infof(msg string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(msg, args...))
+}
+
+func (l *recordingJaegerLogger) Debugf(msg string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(msg, args...))
+}
+
+// infoJaegerLogger - the jaeger logger without the debug level
+type infoJaegerLogger struct {
+	jaeger.Logger
+}
+
+func TestSetStructuredLogger(t *testing.T) {
+	defer SetStructuredLogger(GetLogger())
+
+	l := NewJaegerLogger(&recordingJaegerLogger{})
+	SetStructuredLogger(l)
+	assert.Equal(t, l, GetLogger())
+}
+
+func TestSetLogger(t *testing.T) {
+	defer SetStructuredLogger(GetLogger())
+
+	// the jaeger logger set by the deprecated setter is returned as it is
+	jl := &recordingJaegerLogger{}
+	SetLogger(jl)
+	assert.Equal(t, jl, Logger())
+	GetLogger().Info("info", "method", "GET")
+	assert.Equal(t, []string{"INFO info method=GET"}, jl.lines)
+
+	// the structured logger is adapted to the jaeger logger
+	var buf bytes.Buffer
+	SetStructuredLogger(NewSlogLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+	Logger().Infof("Starting %s server", "http")
+	Logger().Error("failed")
+	assert.Contains(t, buf.String(), `level=INFO msg="Starting http server"`)
+	assert.Contains(t, buf.String(), "level=ERROR msg=failed")
+}
+
+func TestNopLogger(t *testing.T) {
+	l := NopLogger.With("key", "value")
+	l.Debug("debug")
+	l.Info("info")
+	l.Warn("warn")
+	l.Error("error")
+	assert.Equal(t, NopLogger, l)
+}
+
+func TestFormatKeyvals(t *testing.T) {
+	assert.Equal(t, "", formatKeyvals())
+	assert.Equal(t, "method=GET status=200", formatKeyvals("method", "GET", "status", 200))
+	assert.Equal(t, "method=!MISSING", formatKeyvals("method"))
+}
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	l.Debug("debug is filtered")
+	l.With("request_id", "uuid").Info("info", "method", "GET")
+	l.Warn("warn")
+	l.Error("error", "status", 500)
+
+	out := buf.String()
+	assert.NotContains(t, out, "debug is filtered")
+	assert.Contains(t, out, "level=INFO msg=info request_id=uuid method=GET")
+	assert.Contains(t, out, "level=WARN msg=warn")
+	assert.Contains(t, out, "level=ERROR msg=error status=500")
+}
+
+func TestZapLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := NewZapLogger(zap.New(core))
+
+	l.Debug("debug")
+	l.With("request_id", "uuid").Info("info", "method", "GET")
+	l.Warn("warn")
+	l.Error("error")
+
+	entries := logs.AllUntimed()
+	assert.Len(t, entries, 4)
+	assert.Equal(t, zapcore.DebugLevel, entries[0].Level)
+	assert.Equal(t, zapcore.WarnLevel, entries[2].Level)
+	assert.Equal(t, zapcore.ErrorLevel, entries[3].Level)
+	assert.Equal(t, map[string]interface{}{"request_id": "uuid", "method": "GET"}, entries[1].ContextMap())
+}
+
+func TestJaegerLogger(t *testing.T) {
+	jl := &recordingJaegerLogger{}
+	l := NewJaegerLogger(jl)
+
+	l.Debug("debug")
+	l.With("request_id", "uuid").Info("info", "method", "GET")
+	l.Warn("warn 100%")
+	l.Error("error", "status", 500)
+
+	assert.Equal(t, []string{
+		"DEBUG debug",
+		"INFO info request_id=uuid method=GET",
+		"WARN warn 100%",
+		"ERROR error status=500",
+	}, jl.lines)
+
+	// the debug level is discarded without Debugf
+	jl = &recordingJaegerLogger{}
+	l = NewJaegerLogger(infoJaegerLogger{jl})
+	l.Debug("debug")
+	l.Info("info")
+	assert.Equal(t, []string{"INFO info"}, jl.lines)
+}
+
+func TestLoggerFromContext(t *testing.T) {
+	assert.Equal(t, GetLogger(), LoggerFromContext(context.TODO()))
+
+	l := NewJaegerLogger(&recordingJaegerLogger{})
+	ctx := ContextWithLogger(context.TODO(), l)
+	assert.Equal(t, l, LoggerFromContext(ctx))
+}
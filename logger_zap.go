@@ -0,0 +1,32 @@
+package micro
+
+import "go.uber.org/zap"
+
+type zapLogger struct {
+	l *zap.SugaredLogger
+}
+
+// NewZapLogger - create a StructuredLogger which writes to the zap logger
+func NewZapLogger(l *zap.Logger) StructuredLogger {
+	return &zapLogger{l: l.Sugar()}
+}
+
+func (z *zapLogger) Debug(msg string, keyvals ...interface{}) {
+	z.l.Debugw(msg, keyvals...)
+}
+
+func (z *zapLogger) Info(msg string, keyvals ...interface{}) {
+	z.l.Infow(msg, keyvals...)
+}
+
+func (z *zapLogger) Warn(msg string, keyvals ...interface{}) {
+	z.l.Warnw(msg, keyvals...)
+}
+
+func (z *zapLogger) Error(msg string, keyvals ...interface{}) {
+	z.l.Errorw(msg, keyvals...)
+}
+
+func (z *zapLogger) With(keyvals ...interface{}) StructuredLogger {
+	return &zapLogger{l: z.l.With(keyvals...)}
+}
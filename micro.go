@@ -2,12 +2,14 @@ package micro
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
-	"os/signal"
 	"path/filepath"
+	"sync"
 	"syscall"
 	"time"
 
@@ -21,16 +23,25 @@ import (
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	jaeger "github.com/uber/jaeger-client-go"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/propagation"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 // Service - to represent the microservice
 type Service struct {
 	GRPCServer         *grpc.Server
 	HTTPServer         *http.Server
+	HealthServer       *health.Server
 	httpHandler        HTTPHandlerFunc
+	customHTTPHandler  bool
+	errorHandler       runtime.ErrorHandlerFunc
 	annotators         []AnnotatorFunc
 	redoc              *RedocOpts
 	staticDir          string
@@ -39,12 +50,39 @@ type Service struct {
 	streamInterceptors []grpc.StreamServerInterceptor
 	unaryInterceptors  []grpc.UnaryServerInterceptor
 	debug              bool
-	shutdownFunc       func()
+	tracing            TracingMode
+	propagator         propagation.TextMapPropagator
+	shutdownHooks      []shutdownHook
 	shutdownTimeout    time.Duration
 	preShutdownDelay   time.Duration
 	interruptSignals   []os.Signal
 	grpcServerOptions  []grpc.ServerOption
 	grpcDialOptions    []grpc.DialOption
+	gatewayDialOptions []grpc.DialOption
+	grpcCredentials    credentials.TransportCredentials
+	inProcessGateway   bool
+	inProcessLis       *inProcessListener
+	healthChecks       []healthCheck
+	healthTimeout      time.Duration
+	healthInterval     time.Duration
+	grpcAccessLog      *GRPCAccessLogOpts
+	accessLog          *AccessLogOpts
+	auth               *AuthOpts
+	hmac               *HMACOpts
+	authz              *AuthzPolicy
+	tlsOpts            *TLSOpts
+	certs              *certReloader
+	tlsErr             error
+	health             *healthServer
+	upstreams          []upstream
+	registry           Registry
+	instance           *ServiceInstance
+	registered         *ServiceInstance
+	single             bool
+	grpcRequests       inflightRequests
+	mu                 sync.Mutex
+	httpAddr           net.Addr
+	grpcAddr           net.Addr
 }
 
 const (
@@ -52,9 +90,14 @@ const (
 	defaultShutdownTimeout = 30 * time.Second
 	// the default time waiting for running goroutines to finish their jobs before the shutdown starts
 	defaultPreShutdownDelay = 1 * time.Second
+	// the default timeout of each health check
+	defaultHealthCheckTimeout = 5 * time.Second
+	// the default interval to run the health checks in background
+	defaultHealthCheckInterval = 10 * time.Second
 )
 
-// ReverseProxyFunc - a callback that the caller should implement to steps to reverse-proxy the HTTP/1 requests to gRPC
+// ReverseProxyFunc - a callback that the caller should implement to steps to reverse-proxy the HTTP/1 requests to gRPC,
+// the one of the local gRPC server can be nil if the gateway only fronts the upstreams, see Upstream
 type ReverseProxyFunc func(ctx context.Context, mux *runtime.ServeMux, grpcHostAndPort string, opts []grpc.DialOption) error
 
 // HTTPHandlerFunc - http middleware handler function
@@ -68,8 +111,28 @@ func DefaultHTTPHandler(mux *runtime.ServeMux) http.Handler {
 	return InitSpan(mux)
 }
 
+// NewHTTPHandler - create the http handler which initiates the tracing span like InitSpan and
+// extracts the trace context with the propagator, see Propagators
+func NewHTTPHandler(p propagation.TextMapPropagator) HTTPHandlerFunc {
+	return func(mux *runtime.ServeMux) http.Handler {
+		return initSpan(mux, newRouteResolver(protoregistry.GlobalFiles), p)
+	}
+}
+
 // DefaultAnnotator - pass span info into gRPC context
 func DefaultAnnotator(ctx context.Context, req *http.Request) metadata.MD {
+	return annotate(ctx, req, nil)
+}
+
+// NewAnnotator - create the annotator which passes span info into gRPC context like
+// DefaultAnnotator and injects the trace context with the propagator, see Propagators
+func NewAnnotator(p propagation.TextMapPropagator) AnnotatorFunc {
+	return func(ctx context.Context, req *http.Request) metadata.MD {
+		return annotate(ctx, req, p)
+	}
+}
+
+func annotate(ctx context.Context, req *http.Request, p propagation.TextMapPropagator) metadata.MD {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
 		md = metadata.New(nil)
@@ -85,7 +148,9 @@ func DefaultAnnotator(ctx context.Context, req *http.Request) metadata.MD {
 			"child", // this name will be replaced with actual rpc name in the open tracing interceptor
 			opentracing.ChildOf(span.Context()),
 		)
-		md.Set(jaeger.TraceContextHeaderName, fmt.Sprintf("%+v", childSpan))
+		// inject with the propagation formats of the propagator, or the ones of the global tracer,
+		// e.g. uber-trace-id for jaeger
+		tracerWithPropagator(opentracing.GlobalTracer(), p).Inject(childSpan.Context(), opentracing.TextMap, metadataCarrier(md))
 	}
 	if footprint == "" {
 		footprint = RequestID(req)
@@ -110,13 +175,37 @@ func RequestID(req *http.Request) string {
 	return id
 }
 
+type requestIDKey struct{}
+
+// contextWithRequestID - return a copy of the context which carries the request id
+func contextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext - get the request id of the http request or the gRPC call in the context,
+// which is forwarded by the clients created by Dial, empty string is returned if there is none
+func RequestIDFromContext(ctx context.Context) string {
+	if requestID, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return requestID
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get("x-request-id"); len(ids) > 0 {
+			return ids[0]
+		}
+	}
+
+	return ""
+}
+
 func defaultService() *Service {
 	s := Service{}
 	s.annotators = append(s.annotators, DefaultAnnotator)
 	s.httpHandler = DefaultHTTPHandler
-	s.shutdownFunc = func() {}
 	s.shutdownTimeout = defaultShutdownTimeout
 	s.preShutdownDelay = defaultPreShutdownDelay
+	s.healthTimeout = defaultHealthCheckTimeout
+	s.healthInterval = defaultHealthCheckInterval
 
 	s.redoc = &RedocOpts{
 		Up: false,
@@ -155,6 +244,23 @@ func defaultService() *Service {
 	}
 	s.routes = append(s.routes, routeMetrics)
 
+	// add /healthz and /readyz HTTP/1 endpoints
+	routeLiveness := Route{
+		Method:  "GET",
+		Pattern: PathPattern("healthz"),
+		Handler: func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			s.health.serveLiveness(w, r, pathParams)
+		},
+	}
+	routeReadiness := Route{
+		Method:  "GET",
+		Pattern: PathPattern("readyz"),
+		Handler: func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			s.health.serveReadiness(w, r, pathParams)
+		},
+	}
+	s.routes = append(s.routes, routeLiveness, routeReadiness)
+
 	return &s
 }
 
@@ -167,21 +273,94 @@ func NewService(opts ...Option) *Service {
 	// default tracer is NoopTracer, you need to use an acutal tracer for tracing
 	tracer := opentracing.GlobalTracer()
 
+	if s.tlsOpts != nil {
+		s.initTLS()
+	}
+
 	// default dial option is using insecure connection
 	if len(s.grpcDialOptions) == 0 {
 		s.grpcDialOptions = append(s.grpcDialOptions, grpc.WithInsecure())
 	}
 
-	// install open tracing interceptor
-	if s.debug {
-		SetLogger(jaeger.StdLogger)
+	// log in debug level unless a logger is set
+	if s.debug && GetLogger() == NopLogger {
+		SetStructuredLogger(NewSlogLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	}
+
+	if s.tracing != TracingOTel && s.propagator != nil {
+		if _, ok := tracer.(*jaeger.Tracer); !ok {
+			GetLogger().Warn("The propagation formats only apply to the jaeger tracer in the OpenTracing mode, set the global tracer before creating the service")
+		}
+		tracer = tracerWithPropagator(tracer, s.propagator)
+
+		// the default annotator is always the first one
+		s.annotators[0] = NewAnnotator(s.propagator)
+		if !s.customHTTPHandler {
+			s.httpHandler = NewHTTPHandler(s.propagator)
+		}
+	}
+
+	switch {
+	// install OpenTelemetry interceptor, the default tracer provider is noop, you need to use an
+	// actual one for tracing, e.g. InitOTel
+	case s.tracing == TracingOTel:
+		var otelOpts []otelgrpc.Option
+		if s.propagator != nil {
+			otelOpts = append(otelOpts, otelgrpc.WithPropagators(s.propagator))
+		}
+
+		// the default annotator is always the first one
+		s.annotators[0] = NewOTelAnnotator(s.propagator)
+		if !s.customHTTPHandler {
+			s.httpHandler = NewOTelHTTPHandler(s.propagator)
+		}
+		s.streamInterceptors = append(s.streamInterceptors, otelgrpc.StreamServerInterceptor(otelOpts...))
+		s.unaryInterceptors = append(s.unaryInterceptors, otelgrpc.UnaryServerInterceptor(otelOpts...))
+
+	// install open tracing interceptor, the propagation formats are decided by the global tracer
+	// unless they are set by Propagators
+	case s.debug:
 		s.streamInterceptors = append(s.streamInterceptors, otgrpc.OpenTracingStreamServerInterceptor(tracer, otgrpc.LogPayloads()))
 		s.unaryInterceptors = append(s.unaryInterceptors, otgrpc.OpenTracingServerInterceptor(tracer, otgrpc.LogPayloads()))
-	} else {
+	default:
 		s.streamInterceptors = append(s.streamInterceptors, otgrpc.OpenTracingStreamServerInterceptor(tracer))
 		s.unaryInterceptors = append(s.unaryInterceptors, otgrpc.OpenTracingServerInterceptor(tracer))
 	}
 
+	// install context logger interceptor after the tracing one, so that the logger has the span info
+	s.streamInterceptors = append(s.streamInterceptors, StreamLoggerHandler)
+	s.unaryInterceptors = append(s.unaryInterceptors, UnaryLoggerHandler)
+
+	// install access log interceptor after the context logger one, so that it logs with the request info
+	if s.grpcAccessLog != nil {
+		s.streamInterceptors = append(s.streamInterceptors, StreamAccessLogHandler(s.grpcAccessLog))
+		s.unaryInterceptors = append(s.unaryInterceptors, UnaryAccessLogHandler(s.grpcAccessLog))
+	}
+
+	// install authentication interceptor after the access log one, so that the rejected calls are logged
+	if s.auth != nil {
+		s.streamInterceptors = append(s.streamInterceptors, StreamAuthHandler(s.auth))
+		s.unaryInterceptors = append(s.unaryInterceptors, UnaryAuthHandler(s.auth))
+	}
+	if s.hmac != nil {
+		s.streamInterceptors = append(s.streamInterceptors, StreamHMACHandler(s.hmac))
+		s.unaryInterceptors = append(s.unaryInterceptors, UnaryHMACHandler(s.hmac))
+	}
+
+	// install authorization interceptor after the authentication ones, so that it has the principal
+	if s.authz != nil {
+		s.streamInterceptors = append(s.streamInterceptors, StreamAuthzHandler(s.authz))
+		s.unaryInterceptors = append(s.unaryInterceptors, UnaryAuthzHandler(s.authz))
+	}
+
+	// the in-process gateway connection skips the transport security of GRPCCredentials
+	if s.grpcCredentials != nil {
+		s.grpcServerOptions = append(s.grpcServerOptions, grpc.Creds(inProcessCredentials{s.grpcCredentials}))
+	}
+	if s.inProcessGateway {
+		s.inProcessLis = newInProcessListener()
+	}
+
 	s.grpcServerOptions = append(s.grpcServerOptions, grpc_middleware.WithStreamServerChain(s.streamInterceptors...))
 	s.grpcServerOptions = append(s.grpcServerOptions, grpc_middleware.WithUnaryServerChain(s.unaryInterceptors...))
 
@@ -193,6 +372,9 @@ func NewService(opts ...Option) *Service {
 		s.HTTPServer = &http.Server{}
 	}
 
+	s.health = newHealthServer(s.healthChecks, s.healthTimeout, s.healthInterval)
+	s.HealthServer = s.health.Server
+
 	return s
 }
 
@@ -201,12 +383,61 @@ func (s *Service) Getpid() int {
 	return os.Getpid()
 }
 
-// Start - start the microservice with listening on the ports
+// Start - start the microservice with listening on the ports, it blocks until one of the interrupt
+// signals is received
 func (s *Service) Start(httpPort uint16, grpcPort uint16, reverseProxyFunc ReverseProxyFunc) error {
+	ctx, cancel := SignalContext(context.Background(), s.interruptSignals...)
+	defer cancel()
+
+	return s.Run(ctx, httpPort, grpcPort, reverseProxyFunc)
+}
+
+// StartWithListeners - start the microservice with serving on the given listeners, which allows
+// binding to a unix socket, a specific interface, an ephemeral port or a systemd-activated socket,
+// it blocks until one of the interrupt signals is received
+func (s *Service) StartWithListeners(httpLis net.Listener, grpcLis net.Listener, reverseProxyFunc ReverseProxyFunc) error {
+	ctx, cancel := SignalContext(context.Background(), s.interruptSignals...)
+	defer cancel()
+
+	return s.RunWithListeners(ctx, httpLis, grpcLis, reverseProxyFunc)
+}
+
+// Run - run the microservice with listening on the ports until the context is cancelled, then the
+// microservice will be stopped gracefully
+func (s *Service) Run(ctx context.Context, httpPort uint16, grpcPort uint16, reverseProxyFunc ReverseProxyFunc) error {
+	grpcLis, err := net.Listen("tcp", fmt.Sprintf(":%d", grpcPort))
+	if err != nil {
+		return err
+	}
+
+	httpLis, err := net.Listen("tcp", fmt.Sprintf(":%d", httpPort))
+	if err != nil {
+		grpcLis.Close()
+		return err
+	}
+
+	return s.RunWithListeners(ctx, httpLis, grpcLis, reverseProxyFunc)
+}
 
-	// intercept interrupt signals
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, s.interruptSignals...)
+// RunWithListeners - run the microservice with serving on the given listeners until the context is
+// cancelled, then the microservice will be stopped gracefully
+func (s *Service) RunWithListeners(ctx context.Context, httpLis net.Listener, grpcLis net.Listener, reverseProxyFunc ReverseProxyFunc) error {
+	if s.tlsErr != nil {
+		return s.tlsErr
+	}
+	if s.certs != nil {
+		s.certs.start()
+		defer s.certs.stop()
+	}
+	s.health.start()
+	defer s.health.stop()
+
+	s.setAddrs(httpLis.Addr(), grpcLis.Addr())
+
+	// announce the service before serving, so that it fails fast if the registry is unavailable
+	if err := s.register(ctx); err != nil {
+		return err
+	}
 
 	// channels to receive error
 	errChan1 := make(chan error, 1)
@@ -214,17 +445,17 @@ func (s *Service) Start(httpPort uint16, grpcPort uint16, reverseProxyFunc Rever
 
 	// start gRPC server
 	go func() {
-		Logger().Infof("Starting gPRC server listening on %d", grpcPort)
-		errChan1 <- s.startGRPCServer(grpcPort)
+		GetLogger().Info("Starting gRPC server", "addr", grpcLis.Addr().String())
+		errChan1 <- s.startGRPCServer(grpcLis)
 	}()
 
 	// start HTTP/1.0 gateway server
 	go func() {
-		Logger().Infof("Starting http server listening on %d", httpPort)
-		errChan2 <- s.startGRPCGateway(httpPort, grpcPort, reverseProxyFunc)
+		GetLogger().Info("Starting http server", "addr", httpLis.Addr().String())
+		errChan2 <- s.startGRPCGateway(httpLis, dialTarget(grpcLis.Addr()), reverseProxyFunc)
 	}()
 
-	// wait for context cancellation or shutdown signal
+	// wait for context cancellation
 	select {
 	// if gRPC server fail to start
 	case err := <-errChan1:
@@ -234,39 +465,125 @@ func (s *Service) Start(httpPort uint16, grpcPort uint16, reverseProxyFunc Rever
 	case err := <-errChan2:
 		return err
 
-	// if we received an interrupt signal
-	case sig := <-sigChan:
-		Logger().Infof("Interrupt signal received: %v", sig)
-		s.Stop()
-		return nil
+	// if the context is cancelled
+	case <-ctx.Done():
+		GetLogger().Info("Context done, stopping the service", "error", ctx.Err())
+		return s.Stop()
+	}
+}
+
+// HTTPAddr - get the address the http server is bound to, nil if the service is not started
+func (s *Service) HTTPAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.httpAddr
+}
+
+// GRPCAddr - get the address the gRPC server is bound to, nil if the service is not started
+func (s *Service) GRPCAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.grpcAddr
+}
+
+func (s *Service) setAddrs(httpAddr net.Addr, grpcAddr net.Addr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.httpAddr = httpAddr
+	s.grpcAddr = grpcAddr
+}
+
+// dialTarget - get the target for dialing to the given listening address, the unspecified
+// host will be replaced with localhost
+func dialTarget(addr net.Addr) string {
+	if addr.Network() == "unix" {
+		return "unix:" + addr.String()
 	}
+
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+
+	return net.JoinHostPort(host, port)
 }
 
-func (s *Service) startGRPCServer(grpcPort uint16) error {
+func (s *Service) startGRPCServer(lis net.Listener) error {
+	s.registerGRPCServices()
+	s.serveInProcess()
+
+	return s.GRPCServer.Serve(lis)
+}
+
+// registerGRPCServices - register the built-in services on gRPC server
+func (s *Service) registerGRPCServices() {
 	// setup /metrics for prometheus
 	grpc_prometheus.Register(s.GRPCServer)
 
 	// register reflection service on gRPC server.
 	reflection.Register(s.GRPCServer)
 
-	grpcHost := fmt.Sprintf(":%d", grpcPort)
-	lis, err := net.Listen("tcp", grpcHost)
+	// register health service on gRPC server.
+	healthpb.RegisterHealthServer(s.GRPCServer, s.health)
+}
+
+func (s *Service) startGRPCGateway(httpLis net.Listener, grpcHostAndPort string, reverseProxyFunc ReverseProxyFunc) error {
+	err := s.initGateway(grpcHostAndPort, reverseProxyFunc)
 	if err != nil {
 		return err
 	}
 
-	return s.GRPCServer.Serve(lis)
+	s.HTTPServer.Addr = httpLis.Addr().String()
+	s.HTTPServer.Handler = s.gatewayHandler()
+
+	if s.configureHTTPS(false) {
+		return s.HTTPServer.ServeTLS(httpLis, "", "")
+	}
+
+	return s.HTTPServer.Serve(httpLis)
 }
 
-func (s *Service) startGRPCGateway(httpPort uint16, grpcPort uint16, reverseProxyFunc ReverseProxyFunc) error {
-	var muxOptions []runtime.ServeMuxOption
+// initGateway - create the mux, apply the routes and let the reverseProxyFunc register the
+// gRPC handlers which reverse-proxy to grpcHostAndPort
+func (s *Service) initGateway(grpcHostAndPort string, reverseProxyFunc ReverseProxyFunc) error {
+	muxOptions := []runtime.ServeMuxOption{runtime.WithMetadata(HTTPRouteAnnotator)}
 
 	for _, annotator := range s.annotators {
 		muxOptions = append(muxOptions, runtime.WithMetadata(annotator))
 	}
 
+	if s.errorHandler != nil {
+		muxOptions = append(muxOptions, runtime.WithErrorHandler(s.errorHandler))
+	}
+
 	s.mux = runtime.NewServeMux(muxOptions...)
 
+	// this is the fallback handler that will serve static files,
+	// if file does not exist, then a 404 error will be returned.
+	// it must be registered first because the mux gives priority to the handlers registered later.
+	s.mux.Handle("GET", AllPattern(), func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		dir := s.staticDir
+		if s.staticDir == "" {
+			dir, _ = os.Getwd()
+		}
+
+		// check if the file exists and fobid showing directory
+		path := filepath.Join(dir, r.URL.Path)
+		if fileInfo, err := os.Stat(path); os.IsNotExist(err) || fileInfo.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		http.ServeFile(w, r, path)
+	})
+
 	if s.redoc.Up {
 		// add /docs HTTP/1 endpoint
 		routeDocs := Route{
@@ -281,59 +598,122 @@ func (s *Service) startGRPCGateway(httpPort uint16, grpcPort uint16, reverseProx
 
 	// apply routes
 	for _, route := range s.routes {
-		s.mux.Handle(route.Method, route.Pattern, route.Handler)
+		s.mux.Handle(route.Method, route.Pattern, route.handler())
 	}
 
-	err := reverseProxyFunc(context.Background(), s.mux, fmt.Sprintf("localhost:%d", grpcPort), s.grpcDialOptions)
-	if err != nil {
-		return err
+	// the local reverse proxy can be nil if the gateway only fronts the upstreams
+	if reverseProxyFunc != nil {
+		target, dialOptions := s.gatewayTarget(grpcHostAndPort)
+		if s.hmac != nil {
+			dialOptions = append(dialOptions[:len(dialOptions):len(dialOptions)], s.hmac.gatewayDialOptions()...)
+		}
+		err := reverseProxyFunc(context.Background(), s.mux, target, dialOptions)
+		if err != nil {
+			return err
+		}
 	}
 
-	// this is the fallback handler that will serve static files,
-	// if file does not exist, then a 404 error will be returned.
-	s.mux.Handle("GET", AllPattern(), func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-		dir := s.staticDir
-		if s.staticDir == "" {
-			dir, _ = os.Getwd()
+	for _, u := range s.upstreams {
+		GetLogger().Info("Registering upstream", "name", u.name, "target", u.target)
+		err := u.reverseProxyFunc(context.Background(), s.mux, u.target, s.upstreamDialOptions(u))
+		if err != nil {
+			return fmt.Errorf("upstream %s: %w", u.name, err)
 		}
+	}
 
-		// check if the file exists and fobid showing directory
-		path := filepath.Join(dir, r.URL.Path)
-		if fileInfo, err := os.Stat(path); os.IsNotExist(err) || fileInfo.IsDir() {
-			http.NotFound(w, r)
-			return
-		}
+	return nil
+}
 
-		http.ServeFile(w, r, path)
-	})
+// gatewayHandler - the http handler serving the mux with panic recovery, the access log and the
+// authentication
+func (s *Service) gatewayHandler() http.Handler {
+	handler := s.httpHandler(s.mux)
+
+	// the authentication runs before the HMAC verification like the interceptors
+	if s.hmac != nil {
+		handler = HMACHandler(s.hmac)(handler)
+	}
+	if s.auth != nil {
+		handler = AuthHandler(s.auth)(handler)
+	}
+
+	handler = handlers.RecoveryHandler()(handler)
 
-	s.HTTPServer.Addr = fmt.Sprintf(":%d", httpPort)
-	s.HTTPServer.Handler = handlers.RecoveryHandler()(s.httpHandler(s.mux))
-	s.HTTPServer.RegisterOnShutdown(s.shutdownFunc)
+	if s.accessLog != nil {
+		handler = AccessLogHandler(s.accessLog)(handler)
+	}
 
-	return s.HTTPServer.ListenAndServe()
+	return handler
 }
 
-// Stop - stop the microservice gracefully
-func (s *Service) Stop() {
+// Stop - stop the microservice gracefully within the shutdown timeout, the service is deregistered
+// from the registry first. If the timeout expires the servers will be stopped abruptly and an error
+// wrapping ErrShutdownTimeout is returned to report the phase which timed out, the phases left after
+// it are skipped. The shutdown hooks are run after both servers have drained, the errors of every
+// failed phase and hook are joined into the returned error with the name of the phase.
+func (s *Service) Stop() error {
+	var ctx, cancel = context.WithTimeout(
+		context.Background(),
+		s.shutdownTimeout,
+	)
+	defer cancel()
+
+	// withdraw from the registry and report NOT_SERVING first so that the clients and the load
+	// balancers drain us during the preShutdownDelay
+	var errs []error
+	if err := s.deregister(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", PhaseDeregister, err))
+	}
+	s.health.shutdown()
+
 	// disable keep-alives on existing connections
 	s.HTTPServer.SetKeepAlivesEnabled(false)
 
 	// we wait for a duration of preShutdownDelay for running goroutines to finish their jobs
 	if s.preShutdownDelay > 0 {
-		Logger().Infof("Waiting for %v before shutdown starts", s.preShutdownDelay)
-		time.Sleep(s.preShutdownDelay)
+		GetLogger().Info("Waiting before shutdown starts", "delay", s.preShutdownDelay.String())
+		errs = append(errs, waitPhase(ctx, PhasePreShutdownDelay, func() {
+			time.Sleep(s.preShutdownDelay)
+		}))
 	}
 
-	// gracefully stop gRPC server first
-	s.GRPCServer.GracefulStop()
+	if s.single {
+		errs = append(errs, s.stopSingle(ctx))
+	} else {
+		// gracefully stop gRPC server first
+		if err := waitPhase(ctx, PhaseGRPCDrain, s.GRPCServer.GracefulStop); err != nil {
+			s.GRPCServer.Stop()
+			errs = append(errs, err)
+		}
 
-	var ctx, cancel = context.WithTimeout(
-		context.Background(),
-		s.shutdownTimeout,
-	)
-	defer cancel()
+		// gracefully stop http server
+		errs = append(errs, s.stopHTTPServer(ctx))
+	}
+
+	if s.certs != nil {
+		s.certs.stop()
+	}
+
+	errs = append(errs, s.runShutdownHooks(ctx))
+
+	return errors.Join(errs...)
+}
+
+// stopHTTPServer - stop the http server gracefully, close it if the context expires
+func (s *Service) stopHTTPServer(ctx context.Context) error {
+	if ctx.Err() != nil {
+		s.HTTPServer.Close()
+		return shutdownSkippedError(PhaseHTTPDrain)
+	}
+
+	err := s.HTTPServer.Shutdown(ctx)
+	if err != nil && err == ctx.Err() {
+		s.HTTPServer.Close()
+		return shutdownTimeoutError(PhaseHTTPDrain)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", PhaseHTTPDrain, err)
+	}
 
-	// gracefully stop http server
-	s.HTTPServer.Shutdown(ctx)
+	return nil
 }
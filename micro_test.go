@@ -15,11 +15,15 @@ import (
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/stretchr/testify/assert"
+	jaeger "github.com/uber/jaeger-client-go"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 var reverseProxyFunc ReverseProxyFunc
-var httpPort, grpcPort uint16
 var shutdownFunc func()
 
 func init() {
@@ -32,9 +36,6 @@ func init() {
 		return nil
 	}
 
-	httpPort = 8888
-	grpcPort = 9999
-
 	shutdownFunc = func() {
 		fmt.Println("Server shutting down")
 	}
@@ -70,8 +71,18 @@ func TestNewService(t *testing.T) {
 		PreShutdownDelay(0),
 	)
 
+	// bind to ephemeral ports
+	httpLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	go func() {
-		if err := s.Start(httpPort, grpcPort, reverseProxyFunc); err != nil {
+		if err := s.StartWithListeners(httpLis, grpcLis, reverseProxyFunc); err != nil {
 			t.Errorf("failed to serve: %v", err)
 		}
 	}()
@@ -80,18 +91,20 @@ func TestNewService(t *testing.T) {
 	time.Sleep(1 * time.Second)
 
 	// check if the http server is up
-	httpHost := fmt.Sprintf(":%d", httpPort)
-	_, err := net.Listen("tcp", httpHost)
+	httpAddr := s.HTTPAddr().String()
+	_, err = net.Listen("tcp", httpAddr)
 	assert.Error(t, err)
 
 	// check if the grpc server is up
-	grpcHost := fmt.Sprintf(":%d", grpcPort)
-	_, err = net.Listen("tcp", grpcHost)
+	_, err = net.Listen("tcp", s.GRPCAddr().String())
 	assert.Error(t, err)
 
+	httpPort := uint16(s.HTTPAddr().(*net.TCPAddr).Port)
+	grpcPort := uint16(s.GRPCAddr().(*net.TCPAddr).Port)
+
 	// check if the http endpoint works
 	client := &http.Client{}
-	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/", httpPort))
+	resp, err := client.Get("http://" + httpAddr + "/")
 	if err != nil {
 		t.Error(err)
 	}
@@ -99,7 +112,7 @@ func TestNewService(t *testing.T) {
 	assert.Len(t, resp.Header.Get("X-Request-Id"), 36)
 
 	client = &http.Client{}
-	resp, err = client.Get(fmt.Sprintf("http://127.0.0.1:%d/fake.swagger.json", httpPort))
+	resp, err = client.Get("http://" + httpAddr + "/fake.swagger.json")
 	if err != nil {
 		t.Error(err)
 	}
@@ -107,21 +120,21 @@ func TestNewService(t *testing.T) {
 	assert.Len(t, resp.Header.Get("X-Request-Id"), 36)
 
 	client = &http.Client{}
-	resp, err = client.Get(fmt.Sprintf("http://127.0.0.1:%d/demo.swagger.json", httpPort))
+	resp, err = client.Get("http://" + httpAddr + "/demo.swagger.json")
 	if err != nil {
 		t.Error(err)
 	}
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 	assert.Len(t, resp.Header.Get("X-Request-Id"), 36)
 
-	resp, err = client.Get(fmt.Sprintf("http://127.0.0.1:%d/docs", httpPort))
+	resp, err = client.Get("http://" + httpAddr + "/docs")
 	if err != nil {
 		t.Error(err)
 	}
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 	assert.Len(t, resp.Header.Get("X-Request-Id"), 36)
 
-	resp, err = client.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics", httpPort))
+	resp, err = client.Get("http://" + httpAddr + "/metrics")
 	if err != nil {
 		t.Error(err)
 	}
@@ -131,7 +144,7 @@ func TestNewService(t *testing.T) {
 	// create a root span and set uber-trace-id in header
 	rootSpan := opentracing.StartSpan("root")
 	client = &http.Client{}
-	req, err := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1:%d/test", httpPort), nil)
+	req, err := http.NewRequest("GET", "http://"+httpAddr+"/test", nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -156,7 +169,7 @@ func TestNewService(t *testing.T) {
 		}),
 	)
 
-	// grpc port 9999 alreday in use
+	// grpc port alreday in use
 	err = s2.Start(httpPort, grpcPort, reverseProxyFunc)
 	assert.Error(t, err)
 
@@ -167,7 +180,7 @@ func TestNewService(t *testing.T) {
 		}),
 	)
 
-	// http port 8888 already in use
+	// http port already in use
 	s.GRPCServer.Stop()
 	err = s3.Start(httpPort, grpcPort, reverseProxyFunc)
 	assert.Error(t, err)
@@ -179,9 +192,15 @@ func TestNewService(t *testing.T) {
 	s.HTTPServer.Close()
 	s3.GRPCServer.Stop()
 
-	// run a new service, we use different ports to make sure ci not complain
-	httpPort = 18888
-	grpcPort = 19999
+	// run a new service on other ephemeral ports
+	httpLis, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcLis, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
 	s4 := NewService(
 		Redoc(&RedocOpts{
 			Up: false,
@@ -189,7 +208,7 @@ func TestNewService(t *testing.T) {
 		ShutdownTimeout(10*time.Second),
 	)
 	go func() {
-		if err := s4.Start(httpPort, grpcPort, reverseProxyFunc); err != nil {
+		if err := s4.StartWithListeners(httpLis, grpcLis, reverseProxyFunc); err != nil {
 			t.Errorf("failed to serve: %v", err)
 		}
 	}()
@@ -198,7 +217,7 @@ func TestNewService(t *testing.T) {
 	time.Sleep(1 * time.Second)
 
 	// the redoc is not up for the second server
-	resp, err = client.Get(fmt.Sprintf("http://127.0.0.1:%d/docs", httpPort))
+	resp, err = client.Get("http://" + s4.HTTPAddr().String() + "/docs")
 	if err != nil {
 		t.Error(err)
 	}
@@ -212,6 +231,105 @@ func TestNewService(t *testing.T) {
 	time.Sleep(3 * time.Second)
 }
 
+func TestStartWithListeners(t *testing.T) {
+	s := NewService(
+		PreShutdownDelay(0),
+		ShutdownTimeout(5*time.Second),
+	)
+	assert.Nil(t, s.HTTPAddr())
+	assert.Nil(t, s.GRPCAddr())
+
+	// bind to ephemeral ports
+	httpLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.StartWithListeners(httpLis, grpcLis, reverseProxyFunc)
+	}()
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	assert.Equal(t, httpLis.Addr(), s.HTTPAddr())
+	assert.Equal(t, grpcLis.Addr(), s.GRPCAddr())
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", s.HTTPAddr()))
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp.Body.Close()
+	}
+
+	for _, path := range []string{"healthz", "readyz"} {
+		resp, err = http.Get(fmt.Sprintf("http://%s/%s", s.HTTPAddr(), path))
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			resp.Body.Close()
+		}
+	}
+
+	conn, err := grpc.Dial(s.GRPCAddr().String(), grpc.WithInsecure())
+	if assert.NoError(t, err) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		err = conn.Invoke(ctx, "/micro.Test/Ping", &emptypb.Empty{}, &emptypb.Empty{})
+		assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+		// the health service is registered
+		health, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, healthpb.HealthCheckResponse_SERVING, health.Status)
+		}
+		cancel()
+		conn.Close()
+	}
+
+	s.Stop()
+	<-errChan
+}
+
+func TestRun(t *testing.T) {
+	s := NewService(
+		PreShutdownDelay(0),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// bind to ephemeral ports
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.Run(ctx, 0, 0, reverseProxyFunc)
+	}()
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", dialTarget(s.HTTPAddr())))
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp.Body.Close()
+	}
+
+	// the service is stopped gracefully once the context is cancelled
+	cancel()
+	assert.NoError(t, <-errChan)
+
+	_, err = http.Get(fmt.Sprintf("http://%s/metrics", dialTarget(s.HTTPAddr())))
+	assert.Error(t, err)
+}
+
+func TestDialTarget(t *testing.T) {
+	assert.Equal(t, "localhost:8080", dialTarget(&net.TCPAddr{IP: net.IPv6zero, Port: 8080}))
+	assert.Equal(t, "localhost:8080", dialTarget(&net.TCPAddr{IP: net.IPv4zero, Port: 8080}))
+	assert.Equal(t, "127.0.0.1:8080", dialTarget(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}))
+	assert.Equal(t, "unix:/tmp/micro.sock", dialTarget(&net.UnixAddr{Name: "/tmp/micro.sock", Net: "unix"}))
+}
+
 func TestErrorReverseProxyFunc(t *testing.T) {
 	s := NewService(
 		Redoc(&RedocOpts{
@@ -221,7 +339,7 @@ func TestErrorReverseProxyFunc(t *testing.T) {
 
 	// mock error from reverseProxyFunc
 	errText := "reverse proxy func error"
-	reverseProxyFunc = func(
+	errReverseProxyFunc := func(
 		ctx context.Context,
 		mux *runtime.ServeMux,
 		grpcHostAndPort string,
@@ -230,7 +348,13 @@ func TestErrorReverseProxyFunc(t *testing.T) {
 		return errors.New(errText)
 	}
 
-	err := s.startGRPCGateway(httpPort, grpcPort, reverseProxyFunc)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	err = s.startGRPCGateway(lis, "localhost:0", errReverseProxyFunc)
 	assert.EqualError(t, err, errText)
 }
 
@@ -247,3 +371,22 @@ func TestDefaultAnnotator(t *testing.T) {
 	assert.True(t, ok)
 	assert.Equal(t, "uuid", id[0])
 }
+
+func TestDefaultAnnotatorInject(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+	defer opentracing.SetGlobalTracer(opentracing.GlobalTracer())
+	opentracing.SetGlobalTracer(tracer)
+
+	span := tracer.StartSpan("root")
+	ctx := opentracing.ContextWithSpan(context.TODO(), span)
+
+	md := DefaultAnnotator(ctx, httptest.NewRequest("GET", "/", nil))
+
+	// the child span is injected with the propagation format of the global tracer
+	spanCtx, err := tracer.Extract(opentracing.TextMap, metadataCarrier(md))
+	if assert.NoError(t, err) {
+		assert.Equal(t, span.Context().(jaeger.SpanContext).TraceID(), spanCtx.(jaeger.SpanContext).TraceID())
+		assert.Equal(t, span.Context().(jaeger.SpanContext).SpanID(), spanCtx.(jaeger.SpanContext).ParentID())
+	}
+}
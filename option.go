@@ -1,11 +1,14 @@
 package micro
 
 import (
+	"context"
 	"net/http"
 	"os"
 	"time"
 
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 // Option - service functional option
@@ -14,13 +17,33 @@ import (
 // http://dave.cheney.net/2014/10/17/functional-options-for-friendly-apis
 type Option func(s *Service)
 
-// Debug - return an Option to set the service to debug mode or not
+// Debug - return an Option to set the service to debug mode or not, the debug mode logs the payloads
+// of the spans and logs in debug level to stderr unless a logger is set
 func Debug(flag bool) Option {
 	return func(s *Service) {
 		s.debug = flag
 	}
 }
 
+// Tracing - return an Option to set the tracing mode, TracingOpenTracing by default, set it to
+// TracingOTel to migrate to OpenTelemetry
+func Tracing(mode TracingMode) Option {
+	return func(s *Service) {
+		s.tracing = mode
+	}
+}
+
+// Propagators - return an Option to set the formats to propagate the trace context, which are
+// applied on the http extraction, the gateway-to-gRPC metadata injection, the gRPC extraction and
+// the outgoing client calls. The global propagator is used in the TracingOTel mode if it is not set,
+// and the formats of the global tracer in the TracingOpenTracing mode, where the formats only apply
+// to the jaeger tracer which has to be set as the global tracer before the service is created
+func Propagators(formats ...PropagationFormat) Option {
+	return func(s *Service) {
+		s.propagator = NewPropagator(formats...)
+	}
+}
+
 // StaticDir - return an Option to set the staticDir
 func StaticDir(staticDir string) Option {
 	return func(s *Service) {
@@ -46,6 +69,14 @@ func Annotator(annotator AnnotatorFunc) Option {
 func HTTPHandler(httpHandler HTTPHandlerFunc) Option {
 	return func(s *Service) {
 		s.httpHandler = httpHandler
+		s.customHTTPHandler = true
+	}
+}
+
+// ErrorHandler - return an Option to set the errorHandler of the gateway mux
+func ErrorHandler(errorHandler runtime.ErrorHandlerFunc) Option {
+	return func(s *Service) {
+		s.errorHandler = errorHandler
 	}
 }
 
@@ -63,6 +94,49 @@ func StreamInterceptor(streamInterceptor grpc.StreamServerInterceptor) Option {
 	}
 }
 
+// GRPCAccessLog - return an Option to enable the gRPC access log, see NewGRPCAccessLogOpts for the
+// default options
+func GRPCAccessLog(opts *GRPCAccessLogOpts) Option {
+	return func(s *Service) {
+		s.grpcAccessLog = opts
+	}
+}
+
+// AccessLog - return an Option to enable the http access log of the gateway, see NewAccessLogOpts
+// for the default options
+func AccessLog(opts *AccessLogOpts) Option {
+	return func(s *Service) {
+		s.accessLog = opts
+	}
+}
+
+// Authentication - return an Option to authenticate the gRPC calls and the gateway requests, see
+// NewAuthOpts for the default options. The principal is put into the context of the handlers and
+// can be retrieved with PrincipalFromContext, it is not available to the interceptors added by
+// UnaryInterceptor and StreamInterceptor as they are called before the authentication
+func Authentication(opts *AuthOpts) Option {
+	return func(s *Service) {
+		s.auth = opts
+	}
+}
+
+// HMACAuthentication - return an Option to verify the HMAC signed gRPC calls and gateway requests,
+// see NewHMACOpts for the default options and HMACSigner for the clients. The gateway re-signs the
+// calls of the verified requests with the same keys, so the key store has to know the secrets
+func HMACAuthentication(opts *HMACOpts) Option {
+	return func(s *Service) {
+		s.hmac = opts
+	}
+}
+
+// Authorization - return an Option to authorize the gRPC calls with the policy, see LoadAuthzPolicy
+// and AuthzPolicyFromProto, the principal is set by Authentication or HMACAuthentication
+func Authorization(policy *AuthzPolicy) Option {
+	return func(s *Service) {
+		s.authz = policy
+	}
+}
+
 // RouteOpt - return an Option to append a route
 func RouteOpt(route Route) Option {
 	return func(s *Service) {
@@ -70,14 +144,70 @@ func RouteOpt(route Route) Option {
 	}
 }
 
-// ShutdownFunc - return an Option to register a function which will be called when server shutdown
+// HealthCheck - return an Option to register a named health check, e.g. ping the database, which
+// will be run by the gRPC health service and the /readyz endpoint
+func HealthCheck(name string, check HealthCheckFunc) Option {
+	return func(s *Service) {
+		s.healthChecks = append(s.healthChecks, healthCheck{name: name, check: check})
+	}
+}
+
+// HealthCheckTimeout - return an Option to set the timeout of each health check, 5 seconds by
+// default, the check fails once it expires
+func HealthCheckTimeout(timeout time.Duration) Option {
+	return func(s *Service) {
+		s.healthTimeout = timeout
+	}
+}
+
+// HealthCheckInterval - return an Option to set the interval to run the health checks in background
+// while the service is running, 10 seconds by default, so that the gRPC health watchers are notified
+// of the changes. Zero disables the background checks
+func HealthCheckInterval(interval time.Duration) Option {
+	return func(s *Service) {
+		s.healthInterval = interval
+	}
+}
+
+// Upstream - return an Option to proxy the HTTP requests to a remote gRPC backend besides the local
+// gRPC server, the reverseProxyFunc registers the handlers of the backend services, e.g.
+// RegisterUsersHandlerFromEndpoint, with the target and the dial options of the client options,
+// e.g. ClientTLS. The name identifies the upstream in the logs and errors. Note that the routes
+// of the upstreams registered later take priority over the conflicting ones
+func Upstream(name string, target string, reverseProxyFunc ReverseProxyFunc, opts ...ClientOption) Option {
+	return func(s *Service) {
+		s.upstreams = append(s.upstreams, upstream{
+			name:             name,
+			target:           target,
+			reverseProxyFunc: reverseProxyFunc,
+			clientOpts:       opts,
+		})
+	}
+}
+
+// Registration - return an Option to register the instance into the registry when the service
+// starts and deregister it when the service stops, the Addr of the instance is the address of the
+// gRPC server by default
+func Registration(registry Registry, instance ServiceInstance) Option {
+	return func(s *Service) {
+		s.registry = registry
+		s.instance = &instance
+	}
+}
+
+// ShutdownFunc - return an Option to register a function which will be called when server shutdown,
+// it is a shortcut of OnShutdown for the function which does not return an error
 func ShutdownFunc(f func()) Option {
 	return func(s *Service) {
-		s.shutdownFunc = f
+		s.OnShutdown("shutdown func", func(ctx context.Context) error {
+			f()
+			return nil
+		})
 	}
 }
 
-// ShutdownTimeout - return an Option to set the timeout before the server shutdown abruptly
+// ShutdownTimeout - return an Option to set the timeout before the server shutdown abruptly, it
+// bounds the whole shutdown including the preShutdownDelay and the draining of both servers
 func ShutdownTimeout(timeout time.Duration) Option {
 	return func(s *Service) {
 		s.shutdownTimeout = timeout
@@ -106,6 +236,33 @@ func GRPCServerOption(serverOption grpc.ServerOption) Option {
 	}
 }
 
+// GRPCCredentials - return an Option to set the transport credentials of the gRPC server, unlike
+// GRPCServerOption(grpc.Creds(creds)) they are skipped by the in-process gateway connection
+func GRPCCredentials(creds credentials.TransportCredentials) Option {
+	return func(s *Service) {
+		s.grpcCredentials = creds
+	}
+}
+
+// TLS - return an Option to serve gRPC with the certificate files, see NewTLSOpts. The gateway dials
+// with the same certificate and serves HTTPS if it is enabled, the rotated files are reloaded
+// without restarting the service. It overrides GRPCCredentials
+func TLS(opts *TLSOpts) Option {
+	return func(s *Service) {
+		s.tlsOpts = opts
+	}
+}
+
+// InProcessGateway - return an Option to connect the gateway to the gRPC server through an
+// in-memory listener instead of the network, the gRPC port is still served for the other clients.
+// The transport security is skipped if it is set by GRPCCredentials, otherwise the gateway dials
+// with the gRPC dial options
+func InProcessGateway(flag bool) Option {
+	return func(s *Service) {
+		s.inProcessGateway = flag
+	}
+}
+
 // GRPCDialOption - return an Option to append a gRPC dial option
 func GRPCDialOption(dialOption grpc.DialOption) Option {
 	return func(s *Service) {
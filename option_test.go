@@ -12,6 +12,43 @@ import (
 	"google.golang.org/grpc/metadata"
 )
 
+func TestTracing(t *testing.T) {
+	s := NewService(Tracing(TracingOTel))
+	assert.Equal(t, TracingOTel, s.tracing)
+	assert.Len(t, s.annotators, 1)
+	assert.NotNil(t, s.httpHandler)
+	assert.Len(t, s.unaryInterceptors, 5)
+
+	// the custom http handler is kept
+	s = NewService(HTTPHandler(nil), Tracing(TracingOTel))
+	assert.Nil(t, s.httpHandler)
+}
+
+func TestPropagators(t *testing.T) {
+	s := NewService(Propagators(PropagationB3, PropagationJaeger))
+	assert.Contains(t, s.propagator.Fields(), "b3")
+	assert.Contains(t, s.propagator.Fields(), "uber-trace-id")
+
+	s = NewService(Tracing(TracingOTel), Propagators(PropagationB3))
+	assert.Equal(t, []string{"b3"}, s.propagator.Fields())
+	assert.Len(t, s.unaryInterceptors, 5)
+}
+
+func TestDebug(t *testing.T) {
+	defer SetStructuredLogger(GetLogger())
+
+	// a debug logger is installed by default
+	SetStructuredLogger(NopLogger)
+	NewService(Debug(true))
+	assert.NotEqual(t, NopLogger, GetLogger())
+
+	// the logger set is kept
+	l := NewJaegerLogger(&recordingJaegerLogger{})
+	SetStructuredLogger(l)
+	NewService(Debug(true))
+	assert.Equal(t, l, GetLogger())
+}
+
 func TestStaticDir(t *testing.T) {
 	s := NewService(StaticDir("/a/b/c"))
 	assert.Equal(t, "/a/b/c", s.staticDir)
@@ -46,7 +83,7 @@ func TestUnaryInterceptor(t *testing.T) {
 		}),
 	)
 
-	assert.Len(t, s.unaryInterceptors, 5)
+	assert.Len(t, s.unaryInterceptors, 6)
 }
 
 func TestStreamInterceptor(t *testing.T) {
@@ -56,7 +93,31 @@ func TestStreamInterceptor(t *testing.T) {
 		}),
 	)
 
-	assert.Len(t, s.streamInterceptors, 5)
+	assert.Len(t, s.streamInterceptors, 6)
+}
+
+func TestGRPCAccessLog(t *testing.T) {
+	s := NewService(GRPCAccessLog(NewGRPCAccessLogOpts()))
+
+	assert.NotNil(t, s.grpcAccessLog)
+	assert.Len(t, s.unaryInterceptors, 6)
+	assert.Len(t, s.streamInterceptors, 6)
+}
+
+func TestAccessLog(t *testing.T) {
+	s := NewService(AccessLog(NewAccessLogOpts()))
+	assert.NotNil(t, s.accessLog)
+}
+
+func TestHealthCheck(t *testing.T) {
+	s := NewService(
+		HealthCheck("db", func(ctx context.Context) error {
+			return nil
+		}),
+	)
+
+	assert.Len(t, s.healthChecks, 1)
+	assert.Equal(t, "db", s.healthChecks[0].name)
 }
 
 func TestInterruptSignal(t *testing.T) {
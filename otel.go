@@ -0,0 +1,290 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	opentracing "github.com/opentracing/opentracing-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// the name of the tracer which creates the http server spans
+const tracerName = "github.com/minixxie/micro"
+
+// TracingMode - the tracing implementation used by the service
+type TracingMode int
+
+const (
+	// TracingOpenTracing - the legacy tracing with the OpenTracing global tracer, e.g. InitJaeger
+	TracingOpenTracing TracingMode = iota
+	// TracingOTel - the tracing with the OpenTelemetry global tracer provider, e.g. InitOTel, the
+	// trace context is propagated with W3C traceparent and baggage headers by default, see Propagators
+	TracingOTel
+)
+
+// OTelExporter - the exporter of the OpenTelemetry spans
+type OTelExporter string
+
+const (
+	// OTelExporterOTLP - export the spans to the collector with OTLP over gRPC
+	OTelExporterOTLP OTelExporter = "otlp"
+	// OTelExporterStdout - print the spans in JSON, for debugging
+	OTelExporterStdout OTelExporter = "stdout"
+)
+
+// OTelOpts - the options of InitOTel
+type OTelOpts struct {
+	// the service name of the spans
+	ServiceName string
+	// the exporter of the spans, OTelExporterOTLP by default
+	Exporter OTelExporter
+	// the host and port of the OTLP collector, OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4317 by default
+	Endpoint string
+	// connect to the OTLP collector without transport security
+	Insecure bool
+	// the writer of the stdout exporter, os.Stdout by default
+	Writer io.Writer
+	// the formats of the global propagator, PropagationW3C and PropagationBaggage by default
+	Propagators []PropagationFormat
+}
+
+// InitOTel - helper to initiate an OpenTelemetry tracer provider as global tracer provider with the
+// W3C trace context and baggage propagators by default, the returned function flushes and shuts down the
+// tracer provider and can be registered with Service.OnShutdown. If you need to customize your
+// tracer provider, you can do it yourself instead of calling this function
+func InitOTel(opts OTelOpts) (ShutdownHookFunc, error) {
+	if opts.ServiceName == "" {
+		return nil, errors.New("otel: service name is required")
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch opts.Exporter {
+	case OTelExporterStdout:
+		w := opts.Writer
+		if w == nil {
+			w = os.Stdout
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case OTelExporterOTLP, "":
+		var clientOpts []otlptracegrpc.Option
+		if opts.Endpoint != "" {
+			clientOpts = append(clientOpts, otlptracegrpc.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			clientOpts = append(clientOpts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(context.Background(), clientOpts...)
+	default:
+		err = errors.New("otel: unknown exporter " + string(opts.Exporter))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(semconv.ServiceName(opts.ServiceName)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+
+	formats := opts.Propagators
+	if len(formats) == 0 {
+		formats = []PropagationFormat{PropagationW3C, PropagationBaggage}
+	}
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(NewPropagator(formats...))
+
+	return tp.Shutdown, nil
+}
+
+// OTelHTTPHandler - the http handler of the OpenTelemetry tracing mode, which will initiate the
+// tracing span and set the http response header with X-Request-Id
+func OTelHTTPHandler(mux *runtime.ServeMux) http.Handler {
+	return InitOTelSpan(mux)
+}
+
+// NewOTelHTTPHandler - create the http handler of the OpenTelemetry tracing mode which extracts
+// the trace context with the propagator, the global propagator is used if it is nil
+func NewOTelHTTPHandler(p propagation.TextMapPropagator) HTTPHandlerFunc {
+	return func(mux *runtime.ServeMux) http.Handler {
+		return initOTelSpan(mux, p)
+	}
+}
+
+// InitOTelSpan - initiate the OpenTelemetry span from the trace context of the request extracted
+// with the global propagator and set the http response header with X-Request-Id, the span is named
+// with the route template matched by the mux and records the status code and size of the response
+func InitOTelSpan(mux *runtime.ServeMux) http.Handler {
+	return initOTelSpan(mux, nil)
+}
+
+func initOTelSpan(mux *runtime.ServeMux, p propagation.TextMapPropagator) http.Handler {
+	resolver := newRouteResolver(protoregistry.GlobalFiles)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagatorOrGlobal(p).Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		// the sensitive query parameters are never written into the span attributes
+		policy := GetRedactionPolicy()
+
+		// We will be using method name as the span name, the route template is resolved before the
+		// span is started for the sampler, it is renamed if the mux matches a route unknown to the resolver
+		var methodName = r.Method + " " + r.URL.Path
+		if template := resolver.resolve(r); template != "" {
+			methodName = r.Method + " " + template
+		}
+		ctx, serverSpan := otel.Tracer(tracerName).Start(ctx, methodName,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethod(r.Method),
+				attribute.String("http.url.host", r.URL.Hostname()),
+				attribute.String("peer.address", r.RemoteAddr),
+				attribute.String("http.url", policy.RequestURI(r.URL)),
+				attribute.String("http.url.query", policy.Query(r.URL.RawQuery)),
+			),
+		)
+		defer serverSpan.End()
+
+		// the footprint is carried by the baggage through the services
+		bag := baggage.FromContext(ctx)
+		footprint := bag.Member("footprint").Value()
+		if footprint != "" {
+			GetLogger().Debug("Found baggage item footprint", "request_id", footprint)
+		} else {
+			footprint = RequestID(r)
+			GetLogger().Debug("No baggage item footprint found, use X-Request-Id", "request_id", footprint)
+			if member, err := baggage.NewMember("footprint", footprint); err == nil {
+				if bag, err = bag.SetMember(member); err == nil {
+					ctx = baggage.ContextWithBaggage(ctx, bag)
+				}
+			}
+		}
+		serverSpan.SetAttributes(attribute.String("footprint", footprint))
+
+		// Set the http response header with X-Request-Id
+		w.Header().Set("X-Request-Id", footprint)
+
+		// We are passing the logger with the request info
+		keyvals := []interface{}{"request_id", footprint}
+		if traceID, spanID := SpanIDs(ctx); traceID != "" {
+			keyvals = append(keyvals, "trace_id", traceID, "span_id", spanID)
+		}
+		keyvals = append(keyvals, "method", r.Method, "path", r.URL.Path)
+		ctx = ContextWithLogger(ctx, GetLogger().With(keyvals...))
+		ctx = contextWithRequestID(ctx, footprint)
+
+		ctx, route := contextWithHTTPRoute(ctx)
+		recorder := newResponseRecorder(w)
+		mux.ServeHTTP(recorder, r.WithContext(ctx))
+
+		if route.template != "" {
+			serverSpan.SetName(r.Method + " " + route.template)
+			serverSpan.SetAttributes(semconv.HTTPRoute(route.template))
+		}
+		serverSpan.SetAttributes(
+			semconv.HTTPStatusCode(recorder.Status()),
+			semconv.HTTPResponseContentLength(recorder.Size()),
+		)
+		if recorder.Status() >= http.StatusInternalServerError {
+			serverSpan.SetStatus(codes.Error, http.StatusText(recorder.Status()))
+		}
+	})
+}
+
+// OTelAnnotator - pass the OpenTelemetry span and baggage into gRPC context with the global propagator
+func OTelAnnotator(ctx context.Context, req *http.Request) metadata.MD {
+	return otelAnnotate(ctx, req, nil)
+}
+
+// NewOTelAnnotator - create the annotator which passes the OpenTelemetry span and baggage into
+// gRPC context with the propagator, the global propagator is used if it is nil
+func NewOTelAnnotator(p propagation.TextMapPropagator) AnnotatorFunc {
+	return func(ctx context.Context, req *http.Request) metadata.MD {
+		return otelAnnotate(ctx, req, p)
+	}
+}
+
+func otelAnnotate(ctx context.Context, req *http.Request, p propagation.TextMapPropagator) metadata.MD {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		md = metadata.New(nil)
+	}
+
+	propagatorOrGlobal(p).Inject(ctx, metadataCarrier(md))
+
+	footprint := baggage.FromContext(ctx).Member("footprint").Value()
+	if footprint == "" {
+		footprint = RequestID(req)
+	}
+
+	md.Set("x-request-id", footprint)
+
+	return md
+}
+
+// metadataCarrier - the propagation.TextMapCarrier of the gRPC metadata, it is also an
+// opentracing.TextMapReader and opentracing.TextMapWriter
+type metadataCarrier metadata.MD
+
+var _ propagation.TextMapCarrier = metadataCarrier{}
+var _ opentracing.TextMapReader = metadataCarrier{}
+var _ opentracing.TextMapWriter = metadataCarrier{}
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
+
+func (c metadataCarrier) Set(key string, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, strings.ToLower(k))
+	}
+
+	return keys
+}
+
+// ForeachKey - implements opentracing.TextMapReader
+func (c metadataCarrier) ForeachKey(handler func(key, val string) error) error {
+	for k, vs := range c {
+		for _, v := range vs {
+			if err := handler(k, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
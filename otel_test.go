@@ -0,0 +1,142 @@
+package micro
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+// useTestTracerProvider - set the global tracer provider with a span recorder, and restore the
+// globals when the test finishes
+func useTestTracerProvider(t *testing.T) *tracetest.SpanRecorder {
+	tp, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(tp)
+		otel.SetTextMapPropagator(propagator)
+	})
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return recorder
+}
+
+func TestInitOTel(t *testing.T) {
+	tp, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	defer otel.SetTracerProvider(tp)
+	defer otel.SetTextMapPropagator(propagator)
+
+	_, err := InitOTel(OTelOpts{})
+	assert.Error(t, err)
+
+	_, err = InitOTel(OTelOpts{ServiceName: "micro", Exporter: "unknown"})
+	assert.Error(t, err)
+
+	var buf bytes.Buffer
+	shutdown, err := InitOTel(OTelOpts{ServiceName: "micro", Exporter: OTelExporterStdout, Writer: &buf})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, span := otel.Tracer("test").Start(context.TODO(), "test-span")
+	span.End()
+
+	assert.NoError(t, shutdown(context.TODO()))
+	assert.Contains(t, buf.String(), "test-span")
+	assert.Contains(t, buf.String(), "micro")
+}
+
+func TestInitOTelSpan(t *testing.T) {
+	recorder := useTestTracerProvider(t)
+
+	var md metadata.MD
+	mux := runtime.NewServeMux()
+	mux.Handle("GET", PathPattern("test"), func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		md = OTelAnnotator(r.Context(), r)
+	})
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	req.Header.Set("baggage", "footprint=1234567890")
+	resp := httptest.NewRecorder()
+	OTelHTTPHandler(mux).ServeHTTP(resp, req)
+
+	// the footprint in baggage is used as the request id
+	assert.Equal(t, "1234567890", resp.Header().Get("X-Request-Id"))
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "GET /test", spans[0].Name())
+		assert.Equal(t, trace.SpanKindServer, spans[0].SpanKind())
+		assert.Equal(t, traceID, spans[0].SpanContext().TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+	}
+
+	// the span and baggage are passed into gRPC metadata
+	assert.Equal(t, []string{"1234567890"}, md.Get("x-request-id"))
+	assert.Contains(t, md.Get("traceparent")[0], traceID)
+	assert.Equal(t, []string{"footprint=1234567890"}, md.Get("baggage"))
+}
+
+func TestInitOTelSpanRoot(t *testing.T) {
+	recorder := useTestTracerProvider(t)
+
+	var md metadata.MD
+	mux := runtime.NewServeMux()
+	mux.Handle("GET", PathPattern("test"), func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		md = OTelAnnotator(r.Context(), r)
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-Request-Id", "uuid")
+	resp := httptest.NewRecorder()
+	InitOTelSpan(mux).ServeHTTP(resp, req)
+
+	assert.Equal(t, "uuid", resp.Header().Get("X-Request-Id"))
+	assert.Len(t, recorder.Ended(), 1)
+	assert.False(t, recorder.Ended()[0].Parent().IsValid())
+	assert.Equal(t, []string{"uuid"}, md.Get("x-request-id"))
+	assert.Equal(t, []string{"footprint=uuid"}, md.Get("baggage"))
+}
+
+func TestMetadataCarrier(t *testing.T) {
+	c := metadataCarrier(metadata.New(nil))
+	c.Set("Traceparent", "value")
+
+	assert.Equal(t, "value", c.Get("traceparent"))
+	assert.Equal(t, "", c.Get("baggage"))
+	assert.Equal(t, []string{"traceparent"}, c.Keys())
+}
+
+func TestInitOTelSpanRoute(t *testing.T) {
+	recorder := useTestTracerProvider(t)
+
+	InitOTelSpan(newRouteTestMux(http.StatusInternalServerError)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/users/123", nil))
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "GET /v1/users/{id}", spans[0].Name())
+		assert.Equal(t, codes.Error, spans[0].Status().Code)
+		assert.Contains(t, spans[0].Attributes(), semconv.HTTPRoute("/v1/users/{id}"))
+		assert.Contains(t, spans[0].Attributes(), semconv.HTTPStatusCode(http.StatusInternalServerError))
+		assert.Contains(t, spans[0].Attributes(), semconv.HTTPResponseContentLength(4))
+	}
+}
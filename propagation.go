@@ -0,0 +1,175 @@
+package micro
+
+import (
+	"context"
+	"encoding/binary"
+	"strings"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	jaegerclient "github.com/uber/jaeger-client-go"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/contrib/propagators/jaeger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PropagationFormat - the format to propagate the trace context, the values are the same as the
+// ones of the OTEL_PROPAGATORS environment variable
+type PropagationFormat string
+
+const (
+	// PropagationW3C - the W3C traceparent and tracestate headers
+	PropagationW3C PropagationFormat = "tracecontext"
+	// PropagationBaggage - the W3C baggage header
+	PropagationBaggage PropagationFormat = "baggage"
+	// PropagationB3 - the B3 single header "b3"
+	PropagationB3 PropagationFormat = "b3"
+	// PropagationB3Multi - the B3 multiple headers "x-b3-traceid", "x-b3-spanid" and so on
+	PropagationB3Multi PropagationFormat = "b3multi"
+	// PropagationJaeger - the Jaeger "uber-trace-id" header and the "uberctx-" baggage headers
+	PropagationJaeger PropagationFormat = "jaeger"
+)
+
+// NewPropagator - create a composite propagator of the formats, which extracts the trace context
+// from any of the formats and injects it in all of them, the unknown formats are ignored
+func NewPropagator(formats ...PropagationFormat) propagation.TextMapPropagator {
+	var propagators []propagation.TextMapPropagator
+
+	for _, format := range formats {
+		switch format {
+		case PropagationW3C:
+			propagators = append(propagators, propagation.TraceContext{})
+		case PropagationBaggage:
+			propagators = append(propagators, propagation.Baggage{})
+		case PropagationB3:
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case PropagationB3Multi:
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		case PropagationJaeger:
+			propagators = append(propagators, jaeger.Jaeger{})
+		default:
+			GetLogger().Warn("Unknown propagation format is ignored", "format", string(format))
+		}
+	}
+
+	return propagation.NewCompositeTextMapPropagator(propagators...)
+}
+
+// propagatorOrGlobal - return the propagator, or the global one if it is nil
+func propagatorOrGlobal(p propagation.TextMapPropagator) propagation.TextMapPropagator {
+	if p == nil {
+		return otel.GetTextMapPropagator()
+	}
+
+	return p
+}
+
+// propagatingTracer - the OpenTracing tracer which injects and extracts the jaeger span contexts
+// in the http headers and the text maps, e.g. the gRPC metadata, with the propagator, so that the
+// propagation formats apply to the TracingOpenTracing mode as well
+type propagatingTracer struct {
+	opentracing.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// tracerWithPropagator - wrap the tracer with the propagator, the tracer is returned as is if the
+// propagator is nil or the tracer is not the jaeger one whose span contexts can be converted
+func tracerWithPropagator(tracer opentracing.Tracer, p propagation.TextMapPropagator) opentracing.Tracer {
+	if _, ok := tracer.(*jaegerclient.Tracer); !ok || p == nil {
+		return tracer
+	}
+
+	return &propagatingTracer{Tracer: tracer, propagator: p}
+}
+
+// Inject - implements opentracing.Tracer
+func (t *propagatingTracer) Inject(sm opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	sc, ok := sm.(jaegerclient.SpanContext)
+	writer, isWriter := carrier.(opentracing.TextMapWriter)
+	if !ok || !isWriter || (format != opentracing.HTTPHeaders && format != opentracing.TextMap) {
+		return t.Tracer.Inject(sm, format, carrier)
+	}
+
+	var traceID trace.TraceID
+	binary.BigEndian.PutUint64(traceID[:8], sc.TraceID().High)
+	binary.BigEndian.PutUint64(traceID[8:], sc.TraceID().Low)
+	var spanID trace.SpanID
+	binary.BigEndian.PutUint64(spanID[:], uint64(sc.SpanID()))
+	var flags trace.TraceFlags
+	if sc.IsSampled() {
+		flags = trace.FlagsSampled
+	}
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: flags,
+		Remote:     true,
+	}))
+
+	var members []baggage.Member
+	sc.ForeachBaggageItem(func(k, v string) bool {
+		if member, err := baggage.NewMember(k, v); err == nil {
+			members = append(members, member)
+		}
+		return true
+	})
+	if bag, err := baggage.New(members...); err == nil {
+		ctx = baggage.ContextWithBaggage(ctx, bag)
+	}
+
+	t.propagator.Inject(ctx, textMapWriterCarrier{writer})
+	return nil
+}
+
+// Extract - implements opentracing.Tracer
+func (t *propagatingTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	reader, ok := carrier.(opentracing.TextMapReader)
+	if !ok || (format != opentracing.HTTPHeaders && format != opentracing.TextMap) {
+		return t.Tracer.Extract(format, carrier)
+	}
+
+	// the keys of the propagators are in lower case
+	values := propagation.MapCarrier{}
+	err := reader.ForeachKey(func(key, val string) error {
+		values[strings.ToLower(key)] = val
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := t.propagator.Extract(context.Background(), values)
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil, opentracing.ErrSpanContextNotFound
+	}
+
+	traceID, spanID := sc.TraceID(), sc.SpanID()
+	bag := map[string]string{}
+	for _, member := range baggage.FromContext(ctx).Members() {
+		bag[member.Key()] = member.Value()
+	}
+
+	return jaegerclient.NewSpanContext(
+		jaegerclient.TraceID{High: binary.BigEndian.Uint64(traceID[:8]), Low: binary.BigEndian.Uint64(traceID[8:])},
+		jaegerclient.SpanID(binary.BigEndian.Uint64(spanID[:])),
+		0,
+		sc.IsSampled(),
+		bag,
+	), nil
+}
+
+// textMapWriterCarrier - the carrier of the propagator to inject into the OpenTracing carrier
+type textMapWriterCarrier struct {
+	opentracing.TextMapWriter
+}
+
+func (c textMapWriterCarrier) Get(key string) string {
+	return ""
+}
+
+func (c textMapWriterCarrier) Keys() []string {
+	return nil
+}
@@ -0,0 +1,208 @@
+package micro
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+	jaeger "github.com/uber/jaeger-client-go"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID  = "00f067aa0ba902b7"
+)
+
+func testSpanContext(t *testing.T) context.Context {
+	traceID, err := trace.TraceIDFromHex(testTraceID)
+	assert.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex(testSpanID)
+	assert.NoError(t, err)
+
+	return trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+}
+
+func TestNewPropagatorInject(t *testing.T) {
+	ctx := testSpanContext(t)
+
+	tests := []struct {
+		format PropagationFormat
+		header string
+		value  string
+	}{
+		{PropagationW3C, "traceparent", "00-" + testTraceID + "-" + testSpanID + "-01"},
+		{PropagationB3, "b3", testTraceID + "-" + testSpanID + "-1"},
+		{PropagationB3Multi, "x-b3-traceid", testTraceID},
+		{PropagationJaeger, "uber-trace-id", testTraceID + ":" + testSpanID + ":0:1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			header := http.Header{}
+			NewPropagator(tt.format).Inject(ctx, propagation.HeaderCarrier(header))
+			assert.Equal(t, tt.value, header.Get(tt.header))
+		})
+	}
+}
+
+func TestNewPropagatorExtract(t *testing.T) {
+	p := NewPropagator(PropagationW3C, PropagationB3, PropagationB3Multi, PropagationJaeger)
+
+	headers := []http.Header{
+		{"Traceparent": {"00-" + testTraceID + "-" + testSpanID + "-01"}},
+		{"B3": {testTraceID + "-" + testSpanID + "-1"}},
+		{"X-B3-Traceid": {testTraceID}, "X-B3-Spanid": {testSpanID}, "X-B3-Sampled": {"1"}},
+		{"Uber-Trace-Id": {testTraceID + ":" + testSpanID + ":0:1"}},
+	}
+
+	for _, header := range headers {
+		sc := trace.SpanContextFromContext(p.Extract(context.Background(), propagation.HeaderCarrier(header)))
+		assert.Equal(t, testTraceID, sc.TraceID().String())
+		assert.Equal(t, testSpanID, sc.SpanID().String())
+		assert.True(t, sc.IsSampled())
+	}
+}
+
+func TestNewPropagatorUnknown(t *testing.T) {
+	header := http.Header{}
+	NewPropagator("unknown").Inject(testSpanContext(t), propagation.HeaderCarrier(header))
+	assert.Empty(t, header)
+}
+
+func TestNewOTelHTTPHandler(t *testing.T) {
+	recorder := useTestTracerProvider(t)
+	p := NewPropagator(PropagationB3Multi, PropagationJaeger)
+
+	var md metadata.MD
+	mux := runtime.NewServeMux()
+	mux.Handle("GET", PathPattern("test"), func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		md = NewOTelAnnotator(p)(r.Context(), r)
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-B3-Traceid", testTraceID)
+	req.Header.Set("X-B3-Spanid", testSpanID)
+	req.Header.Set("X-B3-Sampled", "1")
+	resp := httptest.NewRecorder()
+	NewOTelHTTPHandler(p)(mux).ServeHTTP(resp, req)
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, testTraceID, spans[0].SpanContext().TraceID().String())
+		assert.Equal(t, testSpanID, spans[0].Parent().SpanID().String())
+	}
+
+	// the trace context is injected in all the formats of the propagator, but not the global ones
+	assert.Equal(t, []string{testTraceID}, md.Get("x-b3-traceid"))
+	assert.Contains(t, md.Get("uber-trace-id")[0], testTraceID)
+	assert.Empty(t, md.Get("traceparent"))
+}
+
+// useTestJaegerTracer - set the global tracer to a jaeger tracer which records the spans
+func useTestJaegerTracer(t *testing.T) *jaeger.InMemoryReporter {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), reporter)
+	global := opentracing.GlobalTracer()
+	opentracing.SetGlobalTracer(tracer)
+	t.Cleanup(func() {
+		opentracing.SetGlobalTracer(global)
+		closer.Close()
+	})
+
+	return reporter
+}
+
+func TestPropagatorsOpenTracing(t *testing.T) {
+	reporter := useTestJaegerTracer(t)
+	s := NewService(InProcessGateway(true), Propagators(PropagationW3C, PropagationBaggage))
+
+	// the http extraction and the gateway injection
+	var md metadata.MD
+	mux := runtime.NewServeMux()
+	mux.Handle("GET", PathPattern("test"), func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		md = s.annotators[0](r.Context(), r)
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Traceparent", "00-"+testTraceID+"-"+testSpanID+"-01")
+	req.Header.Set("Baggage", "footprint=uuid")
+	resp := httptest.NewRecorder()
+	s.httpHandler(mux).ServeHTTP(resp, req)
+
+	spans := reporter.GetSpans()
+	if assert.Len(t, spans, 1) {
+		sc := spans[0].Context().(jaeger.SpanContext)
+		assert.Equal(t, testTraceID, sc.TraceID().String())
+		assert.Equal(t, testSpanID, fmt.Sprintf("%016x", uint64(sc.ParentID())))
+	}
+	assert.Equal(t, "uuid", resp.Header().Get("X-Request-Id"))
+	if assert.Len(t, md.Get("traceparent"), 1) {
+		assert.Contains(t, md.Get("traceparent")[0], testTraceID)
+	}
+	assert.Equal(t, []string{"footprint=uuid"}, md.Get("baggage"))
+	assert.Empty(t, md.Get("uber-trace-id"))
+
+	// the gRPC extraction
+	reporter.Reset()
+	addr := startInProcessService(t, s)
+	conn, err := Dial(addr, ClientTracing(TracingOTel))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "traceparent", "00-"+testTraceID+"-"+testSpanID+"-01")
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+
+	spans = reporter.GetSpans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "/grpc.health.v1.Health/Check", spans[0].(*jaeger.Span).OperationName())
+		assert.Equal(t, testTraceID, spans[0].Context().(jaeger.SpanContext).TraceID().String())
+	}
+
+	// the outgoing client calls
+	backend, calls := startTestGRPCServer(t)
+	conn, err = s.Dial(backend)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	span := opentracing.StartSpan("caller")
+	_, err = healthpb.NewHealthClient(conn).Check(opentracing.ContextWithSpan(context.Background(), span), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+
+	md, _ = metadata.FromIncomingContext(<-calls)
+	if assert.Len(t, md.Get("traceparent"), 1) {
+		assert.Contains(t, md.Get("traceparent")[0], span.Context().(jaeger.SpanContext).TraceID().String())
+	}
+	assert.Empty(t, md.Get("uber-trace-id"))
+}
+
+func TestPropagatingTracerFallback(t *testing.T) {
+	// the formats do not apply to the other tracers
+	tracer := mocktracer.New()
+	assert.Equal(t, tracer, tracerWithPropagator(tracer, NewPropagator(PropagationW3C)))
+
+	jaegerTracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+	assert.Equal(t, jaegerTracer, tracerWithPropagator(jaegerTracer, nil))
+
+	// the span contexts are not found without the headers of the formats
+	_, err := tracerWithPropagator(jaegerTracer, NewPropagator(PropagationB3)).Extract(opentracing.HTTPHeaders,
+		opentracing.HTTPHeadersCarrier(http.Header{"Uber-Trace-Id": {testTraceID + ":" + testSpanID + ":0:1"}}))
+	assert.Equal(t, opentracing.ErrSpanContextNotFound, err)
+}
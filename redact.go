@@ -0,0 +1,128 @@
+package micro
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RedactionPolicy - the policy to redact the sensitive request headers and query parameters before
+// they are written into the logs and the span tags
+type RedactionPolicy struct {
+	// the headers which can be emitted, all the headers except DenyHeaders can be emitted if it is empty
+	AllowHeaders []string
+	// the headers whose values are always redacted, even if they are in AllowHeaders
+	DenyHeaders []string
+	// the query parameters whose values are redacted, case insensitive
+	MaskQueryParams []string
+}
+
+// NewRedactionPolicy - create the redaction policy with the secure defaults, which never emits the
+// credentials in the Authorization, Cookie and API key headers and the common query parameters
+func NewRedactionPolicy() *RedactionPolicy {
+	return &RedactionPolicy{
+		DenyHeaders: []string{
+			"Authorization",
+			"Proxy-Authorization",
+			"Cookie",
+			"Set-Cookie",
+			"X-Api-Key",
+			"Api-Key",
+			"X-Auth-Token",
+			"X-Csrf-Token",
+		},
+		MaskQueryParams: []string{
+			"access_token",
+			"id_token",
+			"refresh_token",
+			"token",
+			"api_key",
+			"apikey",
+			"key",
+			"password",
+			"secret",
+			"client_secret",
+			"signature",
+			"sig",
+		},
+	}
+}
+
+var redactionPolicy = NewRedactionPolicy()
+
+// SetRedactionPolicy - set the redaction policy used by InitSpan, InitOTelSpan and the http access log
+func SetRedactionPolicy(p *RedactionPolicy) {
+	redactionPolicy = p
+}
+
+// GetRedactionPolicy - get the redaction policy
+func GetRedactionPolicy() *RedactionPolicy {
+	return redactionPolicy
+}
+
+// Headers - return a copy of the headers which can be emitted, the values of the denied headers are
+// replaced with "[REDACTED]"
+func (p *RedactionPolicy) Headers(h http.Header) http.Header {
+	redacted := make(http.Header, len(h))
+
+	for k, vs := range h {
+		key := http.CanonicalHeaderKey(k)
+		if len(p.AllowHeaders) > 0 && !containsFold(p.AllowHeaders, key) {
+			continue
+		}
+
+		if containsFold(p.DenyHeaders, key) {
+			redacted[key] = []string{redactedValue}
+			continue
+		}
+
+		redacted[key] = append([]string(nil), vs...)
+	}
+
+	return redacted
+}
+
+// Query - return the raw query with the values of the masked parameters replaced with "[REDACTED]",
+// the order and the encoding of the other parameters are kept
+func (p *RedactionPolicy) Query(rawQuery string) string {
+	if rawQuery == "" || len(p.MaskQueryParams) == 0 {
+		return rawQuery
+	}
+
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		key := param
+		if j := strings.IndexByte(param, '='); j >= 0 {
+			key = param[:j]
+		}
+
+		if name, err := url.QueryUnescape(key); err == nil && containsFold(p.MaskQueryParams, name) {
+			params[i] = key + "=" + redactedValue
+		}
+	}
+
+	return strings.Join(params, "&")
+}
+
+// RequestURI - return the request uri of the url with the masked query parameters redacted
+func (p *RedactionPolicy) RequestURI(u *url.URL) string {
+	uri := u.EscapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+	if u.RawQuery != "" || u.ForceQuery {
+		uri += "?" + p.Query(u.RawQuery)
+	}
+
+	return uri
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,97 @@
+package micro
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestRedactionPolicyHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer secret")
+	h.Set("Cookie", "session=secret")
+	h.Set("x-api-key", "secret")
+	h.Set("User-Agent", "curl")
+
+	redacted := NewRedactionPolicy().Headers(h)
+	assert.Equal(t, http.Header{
+		"Authorization": {"[REDACTED]"},
+		"Cookie":        {"[REDACTED]"},
+		"X-Api-Key":     {"[REDACTED]"},
+		"User-Agent":    {"curl"},
+	}, redacted)
+	// the original headers are not changed
+	assert.Equal(t, "Bearer secret", h.Get("Authorization"))
+
+	// the denied headers are redacted even if they are allowed
+	p := &RedactionPolicy{AllowHeaders: []string{"user-agent", "authorization"}, DenyHeaders: []string{"Authorization"}}
+	assert.Equal(t, http.Header{
+		"Authorization": {"[REDACTED]"},
+		"User-Agent":    {"curl"},
+	}, p.Headers(h))
+}
+
+func TestRedactionPolicyQuery(t *testing.T) {
+	p := NewRedactionPolicy()
+
+	assert.Equal(t, "", p.Query(""))
+	assert.Equal(t, "a=1&Token=[REDACTED]&b=%20&api%5Fkey=[REDACTED]&password=[REDACTED]",
+		p.Query("a=1&Token=secret&b=%20&api%5Fkey=secret&password"))
+
+	u, _ := url.Parse("http://localhost/v1/users?id=1&access_token=secret")
+	assert.Equal(t, "/v1/users?id=1&access_token=[REDACTED]", p.RequestURI(u))
+
+	assert.Equal(t, "token=secret", (&RedactionPolicy{}).Query("token=secret"))
+}
+
+func TestSetRedactionPolicy(t *testing.T) {
+	defer SetRedactionPolicy(GetRedactionPolicy())
+
+	p := &RedactionPolicy{}
+	SetRedactionPolicy(p)
+	assert.Equal(t, p, GetRedactionPolicy())
+}
+
+func TestInitSpanRedaction(t *testing.T) {
+	jl := &recordingJaegerLogger{}
+	defer SetStructuredLogger(GetLogger())
+	SetStructuredLogger(NewJaegerLogger(jl))
+
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+	defer opentracing.SetGlobalTracer(opentracing.GlobalTracer())
+	opentracing.SetGlobalTracer(tracer)
+
+	req := httptest.NewRequest("GET", "/test?id=1&token=secret", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=secret")
+	InitSpan(runtime.NewServeMux()).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.NotEmpty(t, jl.lines)
+	for _, line := range jl.lines {
+		assert.NotContains(t, line, "secret")
+	}
+
+	if assert.Len(t, reporter.GetSpans(), 1) {
+		tags := reporter.GetSpans()[0].(*jaeger.Span).Tags()
+		assert.Equal(t, "/test?id=1&token=[REDACTED]", tags["http.url"])
+		assert.Equal(t, "id=1&token=[REDACTED]", tags["http.url.query"])
+	}
+}
+
+func TestAccessLogRedaction(t *testing.T) {
+	var buf strings.Builder
+	handler := AccessLogHandler(&AccessLogOpts{Writer: &buf})(http.NotFoundHandler())
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test?api_key=secret", nil))
+	assert.Contains(t, buf.String(), `"GET /test?api_key=[REDACTED] HTTP/1.1"`)
+	assert.NotContains(t, buf.String(), "secret")
+}
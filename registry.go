@@ -0,0 +1,171 @@
+package micro
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+// RegistryScheme - the scheme of the gRPC targets resolved by the registry, e.g. "registry:///users"
+const RegistryScheme = "registry"
+
+// ServiceInstance - an instance of a service in the registry
+type ServiceInstance struct {
+	// the name of the service, e.g. "users"
+	Name string `json:"name"`
+	// the unique id of the instance, Name-Addr by default
+	ID string `json:"id"`
+	// the host and port of the gRPC server of the instance
+	Addr string `json:"addr"`
+	// the custom metadata of the instance, e.g. the version or the zone
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Registry - the service registry which the services announce themselves to and the clients
+// discover the services from
+type Registry interface {
+	// Register - register the instance
+	Register(ctx context.Context, instance *ServiceInstance) error
+	// Deregister - deregister the instance
+	Deregister(ctx context.Context, instance *ServiceInstance) error
+	// Watch - watch the instances of the service, the current instances are sent first and then on
+	// every change, the channel is closed when the context is done
+	Watch(ctx context.Context, name string) (<-chan []*ServiceInstance, error)
+}
+
+// register - register the service into the registry with the address of the gRPC server, the
+// unspecified host is replaced with the first non-loopback IP
+func (s *Service) register(ctx context.Context) error {
+	if s.registry == nil {
+		return nil
+	}
+
+	instance := *s.instance
+	if instance.Addr == "" {
+		instance.Addr = advertiseAddr(s.GRPCAddr())
+	}
+	if instance.ID == "" {
+		instance.ID = instance.Name + "-" + instance.Addr
+	}
+
+	GetLogger().Info("Registering service", "name", instance.Name, "id", instance.ID, "addr", instance.Addr)
+	if err := s.registry.Register(ctx, &instance); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.registered = &instance
+	s.mu.Unlock()
+
+	return nil
+}
+
+// deregister - deregister the service from the registry if it is registered
+func (s *Service) deregister(ctx context.Context) error {
+	s.mu.Lock()
+	instance := s.registered
+	s.registered = nil
+	s.mu.Unlock()
+
+	if instance == nil {
+		return nil
+	}
+
+	GetLogger().Info("Deregistering service", "name", instance.Name, "id", instance.ID)
+	return s.registry.Deregister(ctx, instance)
+}
+
+// advertiseAddr - get the address for the other hosts to dial to the listening address, the
+// unspecified host will be replaced with the first non-loopback IP of the interfaces
+func advertiseAddr(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+		if addrs, err := net.InterfaceAddrs(); err == nil {
+			for _, a := range addrs {
+				if ipNet, ok := a.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+					host = ipNet.IP.String()
+					break
+				}
+			}
+		}
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// NewResolverBuilder - create the gRPC resolver builder of the scheme which resolves the targets,
+// e.g. "registry:///users", to the instances of the service in the registry
+func NewResolverBuilder(registry Registry, scheme string) resolver.Builder {
+	return &registryResolverBuilder{registry: registry, scheme: scheme}
+}
+
+// ClientRegistry - return a ClientOption to resolve the "registry:///<service name>" targets with
+// the registry and balance the calls among the instances in round robin
+func ClientRegistry(registry Registry) ClientOption {
+	return ClientDialOption(
+		grpc.WithResolvers(NewResolverBuilder(registry, RegistryScheme)),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"round_robin": {}}]}`),
+	)
+}
+
+type registryResolverBuilder struct {
+	registry Registry
+	scheme   string
+}
+
+func (b *registryResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	name := strings.TrimPrefix(target.Endpoint(), "/")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	instances, err := b.registry.Watch(ctx, name)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go func() {
+		for list := range instances {
+			state := resolver.State{Addresses: make([]resolver.Address, 0, len(list))}
+			for _, instance := range list {
+				var attrs *attributes.Attributes
+				for k, v := range instance.Metadata {
+					attrs = attrs.WithValue(k, v)
+				}
+				state.Addresses = append(state.Addresses, resolver.Address{
+					Addr:       instance.Addr,
+					Attributes: attrs,
+				})
+			}
+
+			if err := cc.UpdateState(state); err != nil {
+				GetLogger().Warn("Failed to update the resolver state", "service", name, "error", err)
+			}
+		}
+	}()
+
+	return &registryResolver{cancel: cancel}, nil
+}
+
+func (b *registryResolverBuilder) Scheme() string {
+	return b.scheme
+}
+
+// registryResolver - the resolver which watches the registry until it is closed
+type registryResolver struct {
+	cancel context.CancelFunc
+}
+
+func (r *registryResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *registryResolver) Close() {
+	r.cancel()
+}
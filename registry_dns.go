@@ -0,0 +1,109 @@
+package micro
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DNSRegistry - the registry which discovers the instances from the DNS SRV records, e.g. the
+// headless services of kubernetes, the instances are registered by the DNS server so Register and
+// Deregister do nothing
+type DNSRegistry struct {
+	// how often the SRV records are looked up
+	Interval time.Duration
+
+	lookupSRV func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+var _ Registry = (*DNSRegistry)(nil)
+
+// NewDNSRegistry - create the DNS SRV registry which looks up the records every 30 seconds
+func NewDNSRegistry() *DNSRegistry {
+	return &DNSRegistry{
+		Interval:  30 * time.Second,
+		lookupSRV: net.DefaultResolver.LookupSRV,
+	}
+}
+
+// Register - implements Registry, the instances are registered by the DNS server
+func (r *DNSRegistry) Register(ctx context.Context, instance *ServiceInstance) error {
+	return nil
+}
+
+// Deregister - implements Registry, the instances are deregistered by the DNS server
+func (r *DNSRegistry) Deregister(ctx context.Context, instance *ServiceInstance) error {
+	return nil
+}
+
+// Watch - implements Registry, the name is either the full SRV record name, e.g.
+// "_grpc._tcp.users.default.svc.cluster.local", or the domain name of the "_grpc._tcp" record
+func (r *DNSRegistry) Watch(ctx context.Context, name string) (<-chan []*ServiceInstance, error) {
+	service, proto := "grpc", "tcp"
+	if strings.HasPrefix(name, "_") {
+		service, proto = "", ""
+	}
+
+	return pollInstances(ctx, r.Interval, func() ([]*ServiceInstance, error) {
+		_, records, err := r.lookupSRV(ctx, service, proto, name)
+		if err != nil {
+			return nil, err
+		}
+
+		instances := make([]*ServiceInstance, 0, len(records))
+		for _, record := range records {
+			addr := net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+			instances = append(instances, &ServiceInstance{Name: name, ID: addr, Addr: addr})
+		}
+
+		return instances, nil
+	}), nil
+}
+
+// pollInstances - fetch the instances every interval and send them when they are changed, the
+// instances are sorted by the ID so that the order of e.g. the DNS answers is not a change, the
+// failures are logged and the last instances are kept
+func pollInstances(ctx context.Context, interval time.Duration, fetch func() ([]*ServiceInstance, error)) <-chan []*ServiceInstance {
+	ch := make(chan []*ServiceInstance, 1)
+
+	go func() {
+		defer close(ch)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var last []*ServiceInstance
+		for first := true; ; first = false {
+			instances, err := fetch()
+			if err != nil {
+				GetLogger().Warn("Failed to fetch the service instances", "error", err)
+			}
+			sort.Slice(instances, func(i, j int) bool {
+				return instances[i].ID < instances[j].ID
+			})
+
+			if first || (err == nil && !reflect.DeepEqual(instances, last)) {
+				if err == nil {
+					last = instances
+				}
+				select {
+				case ch <- copyInstances(last):
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
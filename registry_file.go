@@ -0,0 +1,154 @@
+package micro
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileRegistry - the registry stored in a JSON file of the list of instances, which is watched for
+// changes, it can be used to test the service discovery locally or with a shared volume, the
+// processes sharing the file serialize their updates with a lock file next to it
+type FileRegistry struct {
+	// the path of the JSON file
+	Path string
+	// how often the file is checked for changes
+	Interval time.Duration
+
+	mu sync.Mutex
+}
+
+var _ Registry = (*FileRegistry)(nil)
+
+// fileRegistryStaleLock - the age after which the lock file is considered to be left by a crashed
+// process and is taken over, an update holds the lock for milliseconds only
+const fileRegistryStaleLock = 10 * time.Second
+
+// NewFileRegistry - create the file registry which checks the file every second
+func NewFileRegistry(path string) *FileRegistry {
+	return &FileRegistry{
+		Path:     path,
+		Interval: time.Second,
+	}
+}
+
+// Register - implements Registry
+func (r *FileRegistry) Register(ctx context.Context, instance *ServiceInstance) error {
+	return r.update(ctx, func(instances []*ServiceInstance) []*ServiceInstance {
+		return upsertInstance(instances, instance)
+	})
+}
+
+// Deregister - implements Registry
+func (r *FileRegistry) Deregister(ctx context.Context, instance *ServiceInstance) error {
+	return r.update(ctx, func(instances []*ServiceInstance) []*ServiceInstance {
+		return removeInstance(instances, instance.ID)
+	})
+}
+
+// Watch - implements Registry
+func (r *FileRegistry) Watch(ctx context.Context, name string) (<-chan []*ServiceInstance, error) {
+	return pollInstances(ctx, r.Interval, func() ([]*ServiceInstance, error) {
+		instances, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+
+		var matched []*ServiceInstance
+		for _, instance := range instances {
+			if instance.Name == name {
+				matched = append(matched, instance)
+			}
+		}
+
+		return matched, nil
+	}), nil
+}
+
+// read - read the instances from the file, the missing file has no instances
+func (r *FileRegistry) read() ([]*ServiceInstance, error) {
+	b, err := os.ReadFile(r.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []*ServiceInstance
+	if len(b) > 0 {
+		err = json.Unmarshal(b, &instances)
+	}
+
+	return instances, err
+}
+
+// update - update the instances in the file under the lock, the file is replaced atomically so
+// that the watchers never read a partial file
+func (r *FileRegistry) update(ctx context.Context, f func([]*ServiceInstance) []*ServiceInstance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	instances, err := r.read()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(f(instances), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(r.Path), filepath.Base(r.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), r.Path)
+}
+
+// lock - create the lock file exclusively, it waits until the lock is released by the other process
+// or the context is done
+func (r *FileRegistry) lock(ctx context.Context) (func(), error) {
+	path := r.Path + ".lock"
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > fileRegistryStaleLock {
+			GetLogger().Warn("Removing the stale lock of the file registry", "path", path)
+			os.Remove(path)
+			continue
+		}
+
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
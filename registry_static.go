@@ -0,0 +1,119 @@
+package micro
+
+import (
+	"context"
+	"sync"
+)
+
+// StaticRegistry - the in-memory registry of a static list of instances, which can also be shared
+// by the services and the clients in the same process, e.g. in tests
+type StaticRegistry struct {
+	mu        sync.Mutex
+	instances map[string][]*ServiceInstance
+	watchers  map[string][]chan []*ServiceInstance
+}
+
+var _ Registry = (*StaticRegistry)(nil)
+
+// NewStaticRegistry - create the registry with the static list of instances
+func NewStaticRegistry(instances ...*ServiceInstance) *StaticRegistry {
+	r := &StaticRegistry{
+		instances: make(map[string][]*ServiceInstance),
+		watchers:  make(map[string][]chan []*ServiceInstance),
+	}
+
+	for _, instance := range instances {
+		r.instances[instance.Name] = upsertInstance(r.instances[instance.Name], instance)
+	}
+
+	return r
+}
+
+// Register - implements Registry
+func (r *StaticRegistry) Register(ctx context.Context, instance *ServiceInstance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.instances[instance.Name] = upsertInstance(r.instances[instance.Name], instance)
+	r.notify(instance.Name)
+
+	return nil
+}
+
+// Deregister - implements Registry
+func (r *StaticRegistry) Deregister(ctx context.Context, instance *ServiceInstance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.instances[instance.Name] = removeInstance(r.instances[instance.Name], instance.ID)
+	r.notify(instance.Name)
+
+	return nil
+}
+
+// Watch - implements Registry
+func (r *StaticRegistry) Watch(ctx context.Context, name string) (<-chan []*ServiceInstance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch := make(chan []*ServiceInstance, 1)
+	ch <- copyInstances(r.instances[name])
+	r.watchers[name] = append(r.watchers[name], ch)
+
+	go func() {
+		<-ctx.Done()
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		watchers := r.watchers[name]
+		for i, w := range watchers {
+			if w == ch {
+				r.watchers[name] = append(watchers[:i:i], watchers[i+1:]...)
+				break
+			}
+		}
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+// notify - send the latest instances to the watchers of the service, the stale ones which are not
+// received yet are dropped
+func (r *StaticRegistry) notify(name string) {
+	for _, ch := range r.watchers[name] {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- copyInstances(r.instances[name])
+	}
+}
+
+// upsertInstance - replace the instance with the same id or append it
+func upsertInstance(instances []*ServiceInstance, instance *ServiceInstance) []*ServiceInstance {
+	for i, in := range instances {
+		if in.ID == instance.ID {
+			instances[i] = instance
+			return instances
+		}
+	}
+
+	return append(instances, instance)
+}
+
+// removeInstance - remove the instance with the id
+func removeInstance(instances []*ServiceInstance, id string) []*ServiceInstance {
+	for i, in := range instances {
+		if in.ID == id {
+			return append(instances[:i:i], instances[i+1:]...)
+		}
+	}
+
+	return instances
+}
+
+func copyInstances(instances []*ServiceInstance) []*ServiceInstance {
+	return append([]*ServiceInstance{}, instances...)
+}
@@ -0,0 +1,259 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// receiveInstances - receive the next instances from the watch channel
+func receiveInstances(t *testing.T, ch <-chan []*ServiceInstance) []*ServiceInstance {
+	select {
+	case instances := <-ch:
+		return instances
+	case <-time.After(5 * time.Second):
+		t.Fatal("no instances received")
+		return nil
+	}
+}
+
+func instanceAddrs(instances []*ServiceInstance) []string {
+	addrs := []string{}
+	for _, instance := range instances {
+		addrs = append(addrs, instance.Addr)
+	}
+
+	return addrs
+}
+
+func TestStaticRegistry(t *testing.T) {
+	r := NewStaticRegistry(
+		&ServiceInstance{Name: "users", ID: "1", Addr: "10.0.0.1:9090"},
+		&ServiceInstance{Name: "orders", ID: "2", Addr: "10.0.0.2:9090"},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := r.Watch(ctx, "users")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"10.0.0.1:9090"}, instanceAddrs(receiveInstances(t, ch)))
+
+	r.Register(context.Background(), &ServiceInstance{Name: "users", ID: "3", Addr: "10.0.0.3:9090"})
+	assert.Equal(t, []string{"10.0.0.1:9090", "10.0.0.3:9090"}, instanceAddrs(receiveInstances(t, ch)))
+
+	r.Deregister(context.Background(), &ServiceInstance{Name: "users", ID: "1"})
+	assert.Equal(t, []string{"10.0.0.3:9090"}, instanceAddrs(receiveInstances(t, ch)))
+
+	// the channel is closed when the context is done
+	cancel()
+	for range ch {
+	}
+}
+
+func TestFileRegistry(t *testing.T) {
+	r := NewFileRegistry(filepath.Join(t.TempDir(), "registry.json"))
+	r.Interval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := r.Watch(ctx, "users")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Empty(t, receiveInstances(t, ch))
+
+	assert.NoError(t, r.Register(context.Background(), &ServiceInstance{Name: "users", ID: "1", Addr: "10.0.0.1:9090"}))
+	assert.NoError(t, r.Register(context.Background(), &ServiceInstance{Name: "orders", ID: "2", Addr: "10.0.0.2:9090"}))
+	assert.Equal(t, []string{"10.0.0.1:9090"}, instanceAddrs(receiveInstances(t, ch)))
+
+	assert.NoError(t, r.Deregister(context.Background(), &ServiceInstance{Name: "users", ID: "1"}))
+	assert.Empty(t, receiveInstances(t, ch))
+
+	instances, err := r.read()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.2:9090"}, instanceAddrs(instances))
+}
+
+func TestFileRegistryLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+
+	// the registries of the processes sharing the file do not lose the updates of each other
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			instance := &ServiceInstance{Name: "users", ID: strconv.Itoa(i)}
+			assert.NoError(t, NewFileRegistry(path).Register(context.Background(), instance))
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	instances, err := NewFileRegistry(path).read()
+	assert.NoError(t, err)
+	assert.Len(t, instances, 50)
+
+	// the update waits for the lock held by another process
+	if err := os.WriteFile(path+".lock", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = NewFileRegistry(path).Register(ctx, &ServiceInstance{Name: "users", ID: "50"})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// the stale lock of a crashed process is taken over
+	stale := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(path+".lock", stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, NewFileRegistry(path).Register(context.Background(), &ServiceInstance{Name: "users", ID: "50"}))
+	_, err = os.Stat(path + ".lock")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDNSRegistry(t *testing.T) {
+	var queries []string
+	r := NewDNSRegistry()
+	r.Interval = 10 * time.Millisecond
+	r.lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		queries = append(queries, service+"/"+proto+"/"+name)
+		if len(queries) == 2 {
+			return "", nil, errors.New("temporary failure")
+		}
+		records := []*net.SRV{
+			{Target: "users-0.users.default.svc.cluster.local.", Port: 9090},
+			{Target: "users-1.users.default.svc.cluster.local.", Port: 9090},
+		}
+		// the DNS server rotates the answers
+		if len(queries)%2 == 0 {
+			records[0], records[1] = records[1], records[0]
+		}
+		return "", records, nil
+	}
+
+	assert.NoError(t, r.Register(context.Background(), &ServiceInstance{}))
+	assert.NoError(t, r.Deregister(context.Background(), &ServiceInstance{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := r.Watch(ctx, "users.default.svc.cluster.local")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{
+		"users-0.users.default.svc.cluster.local:9090",
+		"users-1.users.default.svc.cluster.local:9090",
+	}, instanceAddrs(receiveInstances(t, ch)))
+
+	// the unchanged instances in another order and the failures are not sent
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case instances := <-ch:
+		t.Errorf("unexpected instances %v", instanceAddrs(instances))
+	default:
+	}
+	cancel()
+	for range ch {
+	}
+	assert.True(t, len(queries) > 2)
+	assert.Equal(t, "grpc/tcp/users.default.svc.cluster.local", queries[0])
+
+	// the full SRV record name is looked up as it is
+	ctx, cancel = context.WithCancel(context.Background())
+	ch, _ = r.Watch(ctx, "_grpc._tcp.users")
+	receiveInstances(t, ch)
+	cancel()
+	for range ch {
+	}
+	assert.Equal(t, "//_grpc._tcp.users", queries[len(queries)-1])
+}
+
+func TestAdvertiseAddr(t *testing.T) {
+	assert.Equal(t, "127.0.0.1:9090", advertiseAddr(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9090}))
+	assert.NotContains(t, advertiseAddr(&net.TCPAddr{IP: net.IPv6unspecified, Port: 9090}), "::")
+}
+
+func TestRegistration(t *testing.T) {
+	r := NewStaticRegistry()
+	s := NewService(
+		PreShutdownDelay(0),
+		ShutdownTimeout(5*time.Second),
+		Registration(r, ServiceInstance{Name: "test", Metadata: map[string]string{"version": "v1"}}),
+	)
+
+	httpLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.RunWithListeners(ctx, httpLis, grpcLis, reverseProxyFunc)
+	}()
+
+	watchCtx, watchCancel := context.WithCancel(context.Background())
+	defer watchCancel()
+	ch, _ := r.Watch(watchCtx, "test")
+	instances := receiveInstances(t, ch)
+	for len(instances) == 0 {
+		instances = receiveInstances(t, ch)
+	}
+	assert.Equal(t, grpcLis.Addr().String(), instances[0].Addr)
+	assert.Equal(t, "test-"+grpcLis.Addr().String(), instances[0].ID)
+
+	// the clients resolve the service with the registry
+	conn, err := Dial("registry:///test", ClientRegistry(r))
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+		}
+	}
+
+	// the service is deregistered when it stops
+	cancel()
+	<-errChan
+	assert.Empty(t, receiveInstances(t, ch))
+}
+
+type failingRegistry struct {
+	*StaticRegistry
+}
+
+func (failingRegistry) Register(ctx context.Context, instance *ServiceInstance) error {
+	return errors.New("registry unavailable")
+}
+
+func TestRegistrationError(t *testing.T) {
+	s := NewService(Registration(failingRegistry{NewStaticRegistry()}, ServiceInstance{Name: "test"}))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	err = s.RunSingleWithListener(context.Background(), lis, reverseProxyFunc)
+	assert.EqualError(t, err, "registry unavailable")
+}
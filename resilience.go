@@ -0,0 +1,409 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// RetryPolicy - the policy to retry the failed unary calls
+type RetryPolicy struct {
+	// the maximum number of attempts including the first one
+	MaxAttempts int
+	// the status codes which are retried
+	Codes []codes.Code
+	// the backoff before the first retry, the backoff before each retry is randomized between 0 and it
+	InitialBackoff time.Duration
+	// the maximum backoff
+	MaxBackoff time.Duration
+	// the multiplier of the backoff after each retry
+	BackoffMultiplier float64
+}
+
+// NewRetryPolicy - create the retry policy with the default values, which retries the Unavailable
+// calls at most 2 times
+func NewRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:       3,
+		Codes:             []codes.Code{codes.Unavailable},
+		InitialBackoff:    100 * time.Millisecond,
+		MaxBackoff:        time.Second,
+		BackoffMultiplier: 2,
+	}
+}
+
+// backoff - the randomized backoff before the nth retry, starting from 1
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(p.BackoffMultiplier, float64(retry-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+
+	return time.Duration(rand.Float64() * backoff)
+}
+
+// HedgingPolicy - the policy to send the same unary call multiple times without waiting for the
+// response, the first successful response is used and the others are cancelled, it should be used
+// for the idempotent reads only
+type HedgingPolicy struct {
+	// the maximum number of attempts including the first one
+	MaxAttempts int
+	// the delay before sending the next attempt if there is no response
+	Delay time.Duration
+	// the status codes which send the next attempt immediately instead of failing the call
+	NonFatalCodes []codes.Code
+}
+
+// NewHedgingPolicy - create the hedging policy with the default values, which sends the second
+// attempt if there is no response in 100ms
+func NewHedgingPolicy() *HedgingPolicy {
+	return &HedgingPolicy{
+		MaxAttempts:   2,
+		Delay:         100 * time.Millisecond,
+		NonFatalCodes: []codes.Code{codes.Unavailable},
+	}
+}
+
+// CircuitBreakerOpts - the options of the circuit breaker, which opens on the consecutive failures
+// and fails the calls immediately with Unavailable, after OpenTimeout one call is let through and
+// the circuit breaker closes if it succeeds
+type CircuitBreakerOpts struct {
+	// the number of the consecutive failures to open the circuit breaker
+	ConsecutiveFailures int
+	// the duration the circuit breaker stays open before letting a call through
+	OpenTimeout time.Duration
+	// the status codes which are counted as failures
+	Codes []codes.Code
+}
+
+// NewCircuitBreakerOpts - create the circuit breaker options with the default values, which opens
+// on 5 consecutive Unavailable or DeadlineExceeded errors for 30 seconds
+func NewCircuitBreakerOpts() *CircuitBreakerOpts {
+	return &CircuitBreakerOpts{
+		ConsecutiveFailures: 5,
+		OpenTimeout:         30 * time.Second,
+		Codes:               []codes.Code{codes.Unavailable, codes.DeadlineExceeded},
+	}
+}
+
+// ClientRetryPolicy - return a ClientOption to retry the unary calls of the methods, e.g.
+// "/pkg.Service/Method", with the policy, it applies to all the methods if no method is given
+func ClientRetryPolicy(policy *RetryPolicy, methods ...string) ClientOption {
+	return func(c *clientConfig) {
+		if c.retryPolicies == nil {
+			c.retryPolicies = make(map[string]*RetryPolicy)
+		}
+		for _, method := range defaultMethods(methods) {
+			c.retryPolicies[method] = policy
+		}
+	}
+}
+
+// ClientHedgingPolicy - return a ClientOption to hedge the unary calls of the methods, e.g.
+// "/pkg.Service/Method", with the policy, it applies to all the methods if no method is given,
+// the hedging policy takes precedence over the retry policy of the same method
+func ClientHedgingPolicy(policy *HedgingPolicy, methods ...string) ClientOption {
+	return func(c *clientConfig) {
+		if c.hedgingPolicies == nil {
+			c.hedgingPolicies = make(map[string]*HedgingPolicy)
+		}
+		for _, method := range defaultMethods(methods) {
+			c.hedgingPolicies[method] = policy
+		}
+	}
+}
+
+// ClientCircuitBreaker - return a ClientOption to enable the circuit breaker of the client
+// connection for the unary calls, see NewCircuitBreakerOpts for the default options
+func ClientCircuitBreaker(opts *CircuitBreakerOpts) ClientOption {
+	return func(c *clientConfig) {
+		c.circuitBreaker = opts
+	}
+}
+
+// defaultMethods - the methods of the policy, the empty method is the default policy of all the methods
+func defaultMethods(methods []string) []string {
+	if len(methods) == 0 {
+		return []string{""}
+	}
+
+	return methods
+}
+
+var (
+	clientRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_retries_total",
+		Help: "Total number of RPCs retried by the client.",
+	}, []string{"grpc_service", "grpc_method"})
+
+	clientHedges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_hedges_total",
+		Help: "Total number of hedged RPC attempts sent by the client.",
+	}, []string{"grpc_service", "grpc_method"})
+
+	clientCircuitBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grpc_client_circuit_breaker_state",
+		Help: "State of the client circuit breaker, 0 for closed, 1 for open and 2 for half-open.",
+	}, []string{"target"})
+
+	clientCircuitBreakerRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_circuit_breaker_rejected_total",
+		Help: "Total number of RPCs rejected by the open client circuit breaker.",
+	}, []string{"target"})
+)
+
+func init() {
+	prometheus.MustRegister(clientRetries, clientHedges, clientCircuitBreakerState, clientCircuitBreakerRejected)
+}
+
+// splitMethodName - split the full method name into the service and method names
+func splitMethodName(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.Index(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+
+	return "unknown", "unknown"
+}
+
+func containsCode(codes []codes.Code, code codes.Code) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unaryClientResilience - retry or hedge the unary calls according to the policies of the methods
+func unaryClientResilience(retryPolicies map[string]*RetryPolicy, hedgingPolicies map[string]*HedgingPolicy) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if policy := hedgingPolicy(hedgingPolicies, method); policy != nil && policy.MaxAttempts > 1 {
+			if msg, ok := reply.(proto.Message); ok {
+				return hedge(ctx, policy, method, req, msg, cc, invoker, opts...)
+			}
+		}
+
+		if policy := retryPolicy(retryPolicies, method); policy != nil && policy.MaxAttempts > 1 {
+			return retry(ctx, policy, method, req, reply, cc, invoker, opts...)
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// retryPolicy - get the retry policy of the method, or the default one
+func retryPolicy(policies map[string]*RetryPolicy, method string) *RetryPolicy {
+	if policy, ok := policies[method]; ok {
+		return policy
+	}
+
+	return policies[""]
+}
+
+// hedgingPolicy - get the hedging policy of the method, or the default one
+func hedgingPolicy(policies map[string]*HedgingPolicy, method string) *HedgingPolicy {
+	if policy, ok := policies[method]; ok {
+		return policy
+	}
+
+	return policies[""]
+}
+
+func retry(ctx context.Context, policy *RetryPolicy, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	service, name := splitMethodName(method)
+
+	for attempt := 1; ; attempt++ {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil || attempt >= policy.MaxAttempts || !containsCode(policy.Codes, status.Code(err)) {
+			return err
+		}
+
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		LoggerFromContext(ctx).Debug("Retrying gRPC call", "grpc_method", method, "attempt", attempt+1, "error", err)
+		clientRetries.WithLabelValues(service, name).Inc()
+	}
+}
+
+func hedge(ctx context.Context, policy *HedgingPolicy, method string, req interface{}, reply proto.Message, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	service, name := splitMethodName(method)
+
+	// the pending attempts are cancelled when the call returns
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		reply proto.Message
+		err   error
+	}
+	results := make(chan result, policy.MaxAttempts)
+
+	send := func() {
+		attemptReply := proto.Clone(reply)
+		go func() {
+			err := invoker(ctx, method, req, attemptReply, cc, opts...)
+			results <- result{attemptReply, err}
+		}()
+	}
+
+	send()
+	sent, pending := 1, 1
+	timer := time.NewTimer(policy.Delay)
+	defer timer.Stop()
+
+	var err error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if sent < policy.MaxAttempts {
+				send()
+				sent++
+				pending++
+				clientHedges.WithLabelValues(service, name).Inc()
+				timer.Reset(policy.Delay)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				proto.Reset(reply)
+				proto.Merge(reply, r.reply)
+				return nil
+			}
+
+			err = r.err
+			if !containsCode(policy.NonFatalCodes, status.Code(err)) {
+				return err
+			}
+
+			// send the next attempt immediately on the non-fatal errors
+			if sent < policy.MaxAttempts {
+				send()
+				sent++
+				pending++
+				clientHedges.WithLabelValues(service, name).Inc()
+				timer.Reset(policy.Delay)
+			}
+		}
+	}
+
+	return err
+}
+
+// the states of the circuit breaker
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+var circuitStateNames = []string{"closed", "open", "half-open"}
+
+// circuitBreaker - the circuit breaker of a client connection
+type circuitBreaker struct {
+	opts   *CircuitBreakerOpts
+	target string
+	now    func() time.Time
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(target string, opts *CircuitBreakerOpts) *circuitBreaker {
+	b := &circuitBreaker{opts: opts, target: target, now: time.Now}
+	clientCircuitBreakerState.WithLabelValues(target).Set(circuitClosed)
+
+	return b
+}
+
+// allow - check if the call can be sent, only one call is let through when it is half-open
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitOpen && b.now().Sub(b.openedAt) >= b.opts.OpenTimeout {
+		b.setState(circuitHalfOpen)
+	}
+
+	switch b.state {
+	case circuitOpen:
+		return false
+	case circuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+
+	return true
+}
+
+// record - record the result of the call let through, a call cancelled by the caller tells nothing
+// about the target and neither does a probe over the deadline of the caller, they only release the
+// probe and leave the state unchanged
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if err != nil && (errors.Is(ctx.Err(), context.Canceled) || (b.state == circuitHalfOpen && ctx.Err() != nil)) {
+		return
+	}
+
+	if !containsCode(b.opts.Codes, status.Code(err)) {
+		b.failures = 0
+		b.setState(circuitClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.opts.ConsecutiveFailures {
+		b.openedAt = b.now()
+		b.setState(circuitOpen)
+	}
+}
+
+func (b *circuitBreaker) setState(state int) {
+	if b.state != state {
+		GetLogger().Info("Circuit breaker state changed",
+			"target", b.target, "from", circuitStateNames[b.state], "to", circuitStateNames[state])
+	}
+	b.state = state
+	clientCircuitBreakerState.WithLabelValues(b.target).Set(float64(state))
+}
+
+// unaryClientCircuitBreaker - fail the unary calls immediately with Unavailable when the circuit
+// breaker is open
+func unaryClientCircuitBreaker(b *circuitBreaker) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !b.allow() {
+			clientCircuitBreakerRejected.WithLabelValues(b.target).Inc()
+			return status.Errorf(codes.Unavailable, "circuit breaker is open for %s", b.target)
+		}
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		b.record(ctx, err)
+
+		return err
+	}
+}
@@ -0,0 +1,234 @@
+package micro
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// failingInvoker - the invoker which fails with the codes in order and succeeds afterwards
+func failingInvoker(calls *int32, failures ...codes.Code) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		n := int(atomic.AddInt32(calls, 1))
+		if n <= len(failures) {
+			return status.Error(failures[n-1], "failure")
+		}
+		return nil
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := &RetryPolicy{
+		MaxAttempts:       3,
+		Codes:             []codes.Code{codes.Unavailable},
+		InitialBackoff:    time.Millisecond,
+		MaxBackoff:        time.Millisecond,
+		BackoffMultiplier: 2,
+	}
+	interceptor := unaryClientResilience(map[string]*RetryPolicy{"/pb.Test/Get": policy}, nil)
+	retries := testutil.ToFloat64(clientRetries.WithLabelValues("pb.Test", "Get"))
+
+	// succeed after the retries
+	var calls int32
+	err := interceptor(context.Background(), "/pb.Test/Get", nil, nil, nil, failingInvoker(&calls, codes.Unavailable, codes.Unavailable))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), calls)
+	assert.Equal(t, retries+2, testutil.ToFloat64(clientRetries.WithLabelValues("pb.Test", "Get")))
+
+	// fail after the max attempts
+	calls = 0
+	err = interceptor(context.Background(), "/pb.Test/Get", nil, nil, nil, failingInvoker(&calls, codes.Unavailable, codes.Unavailable, codes.Unavailable))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, int32(3), calls)
+
+	// the other codes are not retried
+	calls = 0
+	err = interceptor(context.Background(), "/pb.Test/Get", nil, nil, nil, failingInvoker(&calls, codes.InvalidArgument))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, int32(1), calls)
+
+	// the other methods are not retried
+	calls = 0
+	err = interceptor(context.Background(), "/pb.Test/Set", nil, nil, nil, failingInvoker(&calls, codes.Unavailable))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, int32(1), calls)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := NewRetryPolicy()
+	for retry := 1; retry <= 10; retry++ {
+		backoff := policy.backoff(retry)
+		assert.True(t, backoff >= 0 && backoff <= policy.MaxBackoff)
+	}
+
+	// the retry stops when the context is done during the backoff
+	policy.InitialBackoff = time.Hour
+	policy.MaxBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	var calls int32
+	err := unaryClientResilience(map[string]*RetryPolicy{"": policy}, nil)(ctx, "/pb.Test/Get", nil, nil, nil, failingInvoker(&calls, codes.Unavailable))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestHedgingPolicy(t *testing.T) {
+	policy := &HedgingPolicy{MaxAttempts: 3, Delay: 10 * time.Millisecond, NonFatalCodes: []codes.Code{codes.Unavailable}}
+	interceptor := unaryClientResilience(nil, map[string]*HedgingPolicy{"": policy})
+	hedges := testutil.ToFloat64(clientHedges.WithLabelValues("pb.Test", "Get"))
+
+	// the first attempt hangs and the second one responds
+	var calls int32
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-ctx.Done()
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		reply.(*healthpb.HealthCheckResponse).Status = healthpb.HealthCheckResponse_SERVING
+		return nil
+	}
+
+	reply := &healthpb.HealthCheckResponse{}
+	err := interceptor(context.Background(), "/pb.Test/Get", nil, reply, nil, invoker)
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, reply.Status)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, hedges+1, testutil.ToFloat64(clientHedges.WithLabelValues("pb.Test", "Get")))
+
+	// the next attempt is sent immediately on the non-fatal errors without waiting for the delay
+	policy.Delay = time.Hour
+	calls = 0
+	err = interceptor(context.Background(), "/pb.Test/Get", nil, &healthpb.HealthCheckResponse{}, nil, failingInvoker(&calls, codes.Unavailable, codes.Unavailable, codes.Unavailable))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// the call fails on the fatal error
+	calls = 0
+	err = interceptor(context.Background(), "/pb.Test/Get", nil, &healthpb.HealthCheckResponse{}, nil, failingInvoker(&calls, codes.NotFound))
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker("test", &CircuitBreakerOpts{
+		ConsecutiveFailures: 2,
+		OpenTimeout:         time.Minute,
+		Codes:               []codes.Code{codes.Unavailable, codes.DeadlineExceeded},
+	})
+	b.now = func() time.Time { return now }
+	interceptor := unaryClientCircuitBreaker(b)
+	rejected := testutil.ToFloat64(clientCircuitBreakerRejected.WithLabelValues("test"))
+
+	var calls int32
+	invoker := failingInvoker(&calls, codes.Unavailable, codes.InvalidArgument, codes.Unavailable, codes.DeadlineExceeded, codes.Unavailable)
+	call := func() error {
+		return interceptor(context.Background(), "/pb.Test/Get", nil, nil, nil, invoker)
+	}
+
+	// the other codes reset the consecutive failures
+	call()
+	call()
+	call()
+	assert.Equal(t, circuitClosed, b.state)
+	call()
+	assert.Equal(t, circuitOpen, b.state)
+	assert.Equal(t, float64(circuitOpen), testutil.ToFloat64(clientCircuitBreakerState.WithLabelValues("test")))
+
+	// the calls fail immediately when it is open
+	err := call()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Contains(t, err.Error(), "circuit breaker is open")
+	assert.Equal(t, int32(4), calls)
+	assert.Equal(t, rejected+1, testutil.ToFloat64(clientCircuitBreakerRejected.WithLabelValues("test")))
+
+	// it opens again if the call let through fails
+	now = now.Add(time.Minute)
+	assert.Error(t, call())
+	assert.Equal(t, int32(5), calls)
+	assert.Equal(t, circuitOpen, b.state)
+
+	// it closes if the call let through succeeds
+	now = now.Add(time.Minute)
+	assert.True(t, b.allow())
+	assert.False(t, b.allow(), "only one call is let through when it is half-open")
+	b.record(context.Background(), nil)
+	assert.Equal(t, circuitClosed, b.state)
+	assert.NoError(t, call())
+}
+
+func TestCircuitBreakerCallerContext(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker("test", &CircuitBreakerOpts{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         time.Minute,
+		Codes:               []codes.Code{codes.Unavailable, codes.DeadlineExceeded},
+	})
+	b.now = func() time.Time { return now }
+	interceptor := unaryClientCircuitBreaker(b)
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		<-ctx.Done()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+
+	// the calls cancelled by the caller are not failures
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, codes.Canceled, status.Code(interceptor(ctx, "/pb.Test/Get", nil, nil, nil, invoker)))
+	assert.Equal(t, circuitClosed, b.state)
+
+	b.record(context.Background(), status.Error(codes.Unavailable, "failure"))
+	assert.Equal(t, circuitOpen, b.state)
+
+	// the probe cancelled or over the deadline of the caller releases the probe in half-open
+	now = now.Add(time.Minute)
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, codes.Canceled, status.Code(interceptor(ctx, "/pb.Test/Get", nil, nil, nil, invoker)))
+	assert.Equal(t, circuitHalfOpen, b.state)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(interceptor(ctx, "/pb.Test/Get", nil, nil, nil, invoker)))
+	assert.Equal(t, circuitHalfOpen, b.state)
+
+	// the next probe decides the state
+	assert.True(t, b.allow())
+	b.record(context.Background(), nil)
+	assert.Equal(t, circuitClosed, b.state)
+}
+
+func TestDialCircuitBreaker(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	conn, err := Dial(addr,
+		ClientCircuitBreaker(&CircuitBreakerOpts{ConsecutiveFailures: 1, OpenTimeout: time.Minute, Codes: []codes.Code{codes.Unavailable}}),
+		ClientRetryPolicy(&RetryPolicy{MaxAttempts: 2, Codes: []codes.Code{codes.Unavailable}}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.NotContains(t, err.Error(), "circuit breaker is open")
+
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Contains(t, err.Error(), "circuit breaker is open for "+addr)
+}
@@ -1,8 +1,17 @@
 package micro
 
 import (
+	"context"
+	"net/http"
+	"net/url"
+
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/utilities"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 // Route - represent the route for mux
@@ -21,3 +30,149 @@ func PathPattern(path string) runtime.Pattern {
 func AllPattern() runtime.Pattern {
 	return runtime.MustPattern(runtime.NewPattern(1, []int{int(utilities.OpPush), 0}, []string{""}, ""))
 }
+
+type httpRouteKey struct{}
+
+// httpRoute - the route template of the request, e.g. "/v1/users/{id}", which is recorded when
+// the mux matches the request
+type httpRoute struct {
+	template string
+}
+
+// contextWithHTTPRoute - return a copy of the context which records the route template matched by the mux
+func contextWithHTTPRoute(ctx context.Context) (context.Context, *httpRoute) {
+	route := &httpRoute{}
+	return context.WithValue(ctx, httpRouteKey{}, route), route
+}
+
+// setHTTPRoute - record the route template into the context created by contextWithHTTPRoute
+func setHTTPRoute(ctx context.Context, template string) {
+	if route, ok := ctx.Value(httpRouteKey{}).(*httpRoute); ok {
+		route.template = template
+	}
+}
+
+// HTTPRouteAnnotator - record the route template of the gateway handlers for naming the spans of
+// InitSpan and InitOTelSpan, it is installed by the service and is required only if you serve
+// your own mux with InitSpan or InitOTelSpan
+func HTTPRouteAnnotator(ctx context.Context, req *http.Request) metadata.MD {
+	if template, ok := runtime.HTTPPathPattern(ctx); ok {
+		setHTTPRoute(ctx, template)
+	}
+
+	return nil
+}
+
+// handler - the handler of the route which records the pattern as the route template
+func (r Route) handler() runtime.HandlerFunc {
+	template := r.Pattern.String()
+
+	return func(w http.ResponseWriter, req *http.Request, pathParams map[string]string) {
+		setHTTPRoute(req.Context(), template)
+		r.Handler(w, req, pathParams)
+	}
+}
+
+// routeResolver - match the requests with the route templates of the google.api.http options of
+// the registered services, so that the spans can be named by the templates before they are started
+// and sampled, e.g. by the per-operation sampler
+type routeResolver struct {
+	mux *runtime.ServeMux
+}
+
+// newRouteResolver - create the resolver of the http rules in the files
+func newRouteResolver(files *protoregistry.Files) *routeResolver {
+	r := &routeResolver{mux: runtime.NewServeMux()}
+
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		services := file.Services()
+		for i := 0; i < services.Len(); i++ {
+			methods := services.Get(i).Methods()
+			for j := 0; j < methods.Len(); j++ {
+				opts := methods.Get(j).Options()
+				if opts == nil || !proto.HasExtension(opts, annotations.E_Http) {
+					continue
+				}
+				if rule, ok := proto.GetExtension(opts, annotations.E_Http).(*annotations.HttpRule); ok {
+					r.add(rule)
+				}
+			}
+		}
+		return true
+	})
+
+	return r
+}
+
+// add - match the pattern of the rule and its additional bindings in the same way as the gateway
+func (r *routeResolver) add(rule *annotations.HttpRule) {
+	var method, template string
+	switch pattern := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		method, template = "GET", pattern.Get
+	case *annotations.HttpRule_Put:
+		method, template = "PUT", pattern.Put
+	case *annotations.HttpRule_Post:
+		method, template = "POST", pattern.Post
+	case *annotations.HttpRule_Delete:
+		method, template = "DELETE", pattern.Delete
+	case *annotations.HttpRule_Patch:
+		method, template = "PATCH", pattern.Patch
+	case *annotations.HttpRule_Custom:
+		method, template = pattern.Custom.GetKind(), pattern.Custom.GetPath()
+	}
+
+	if template != "" {
+		err := r.mux.HandlePath(method, template, func(w http.ResponseWriter, req *http.Request, pathParams map[string]string) {
+			setHTTPRoute(req.Context(), template)
+		})
+		if err != nil {
+			GetLogger().Warn("Invalid http rule", "method", method, "template", template, "error", err)
+		}
+	}
+
+	for _, binding := range rule.GetAdditionalBindings() {
+		r.add(binding)
+	}
+}
+
+// resolve - the route template of the request, empty if it matches none. The request is matched
+// on a copy without the body and the form, so that they are left to the gateway, e.g. the form of
+// a POST request which falls back to GET
+func (r *routeResolver) resolve(req *http.Request) string {
+	ctx, route := contextWithHTTPRoute(req.Context())
+	match := req.Clone(ctx)
+	match.Body = http.NoBody
+	match.ContentLength = 0
+	match.PostForm = url.Values{}
+	r.mux.ServeHTTP(discardResponseWriter{header: http.Header{}}, match)
+
+	// the mux tries the fallback on the other methods in random order, so a route of e.g. HEAD on
+	// the same path may be hit first, match the GET routes of the form POST requests explicitly
+	if route.template == "" && isPathLengthFallback(req) {
+		match.Method = http.MethodGet
+		r.mux.ServeHTTP(discardResponseWriter{header: http.Header{}}, match)
+	}
+
+	return route.template
+}
+
+// isPathLengthFallback - whether the gateway falls back from POST to GET for the request
+func isPathLengthFallback(req *http.Request) bool {
+	return req.Method == http.MethodPost && req.Header.Get("Content-Type") == "application/x-www-form-urlencoded"
+}
+
+// discardResponseWriter - the response writer of the route resolver which discards the responses
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w discardResponseWriter) WriteHeader(int) {}
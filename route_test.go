@@ -0,0 +1,30 @@
+package micro
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteHandler(t *testing.T) {
+	var called bool
+	route := Route{
+		Method:  "GET",
+		Pattern: PathPattern("test"),
+		Handler: func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			called = true
+		},
+	}
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	ctx, httpRoute := contextWithHTTPRoute(req.Context())
+	route.handler()(httptest.NewRecorder(), req.WithContext(ctx), nil)
+
+	assert.True(t, called)
+	assert.Equal(t, "/test", httpRoute.template)
+
+	// nothing is recorded without contextWithHTTPRoute
+	route.handler()(httptest.NewRecorder(), req, nil)
+}
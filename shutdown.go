@@ -0,0 +1,103 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrShutdownTimeout - the error reported when the shutdown timeout expires before the service is
+// stopped gracefully
+var ErrShutdownTimeout = errors.New("shutdown timeout")
+
+// the phases of the graceful shutdown
+const (
+	// PhaseDeregister - withdrawing the service from the registry
+	PhaseDeregister = "deregister"
+	// PhasePreShutdownDelay - waiting for the running goroutines to finish their jobs
+	PhasePreShutdownDelay = "pre-shutdown delay"
+	// PhaseGRPCDrain - waiting for the running gRPC requests to finish
+	PhaseGRPCDrain = "grpc drain"
+	// PhaseHTTPDrain - waiting for the running http requests to finish
+	PhaseHTTPDrain = "http drain"
+)
+
+// ShutdownHookFunc - a function which will be called when server shutdown, e.g. close the database
+type ShutdownHookFunc func(ctx context.Context) error
+
+type shutdownHook struct {
+	name string
+	hook ShutdownHookFunc
+}
+
+// OnShutdown - register a named hook which will be called after both servers have drained, the
+// hooks are called one by one in the reverse order of registration with the context of the shutdown
+// timeout, the remaining hooks are skipped once it expires
+func (s *Service) OnShutdown(name string, hook ShutdownHookFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.shutdownHooks = append(s.shutdownHooks, shutdownHook{name: name, hook: hook})
+}
+
+// runShutdownHooks - call the shutdown hooks synchronously in LIFO order and aggregate their errors,
+// a hook is not started after the context expires so that the order is kept
+func (s *Service) runShutdownHooks(ctx context.Context) error {
+	s.mu.Lock()
+	hooks := s.shutdownHooks
+	s.mu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		phase := "shutdown hook " + h.name
+
+		if ctx.Err() != nil {
+			errs = append(errs, shutdownSkippedError(phase))
+			continue
+		}
+
+		GetLogger().Info("Running shutdown hook", "hook", h.name)
+
+		if err := h.hook(ctx); err != nil {
+			GetLogger().Error("Shutdown hook failed", "hook", h.name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", phase, err))
+		} else if ctx.Err() != nil {
+			// the hook returned after the shutdown timeout
+			errs = append(errs, shutdownTimeoutError(phase))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// waitPhase - run f and wait for it to return, an error is returned if the context expires first,
+// f is not started if the context has expired already
+func waitPhase(ctx context.Context, phase string, f func()) error {
+	if ctx.Err() != nil {
+		return shutdownSkippedError(phase)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return shutdownTimeoutError(phase)
+	}
+}
+
+func shutdownTimeoutError(phase string) error {
+	GetLogger().Error("Shutdown timeout", "phase", phase)
+	return fmt.Errorf("%s: %w", phase, ErrShutdownTimeout)
+}
+
+func shutdownSkippedError(phase string) error {
+	GetLogger().Error("Shutdown phase skipped", "phase", phase)
+	return fmt.Errorf("%s: skipped after %w", phase, ErrShutdownTimeout)
+}
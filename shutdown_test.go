@@ -0,0 +1,220 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// blockingServiceDesc - a service with a streaming rpc which never finishes until it is cancelled
+var blockingServiceDesc = grpc.ServiceDesc{
+	ServiceName: "micro.Blocking",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "Block",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				<-stream.Context().Done()
+				return stream.Context().Err()
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+}
+
+func TestWaitPhase(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	assert.NoError(t, waitPhase(ctx, PhaseGRPCDrain, func() {}))
+
+	err := waitPhase(ctx, PhaseHTTPDrain, func() {
+		time.Sleep(1 * time.Second)
+	})
+	assert.True(t, errors.Is(err, ErrShutdownTimeout))
+	assert.EqualError(t, err, "http drain: shutdown timeout")
+
+	// the phase is not started once the timeout has expired
+	started := false
+	err = waitPhase(ctx, PhaseGRPCDrain, func() { started = true })
+	assert.False(t, started)
+	assert.EqualError(t, err, "grpc drain: skipped after shutdown timeout")
+}
+
+func TestStopPreShutdownDelayTimeout(t *testing.T) {
+	s := NewService(
+		PreShutdownDelay(1*time.Second),
+		ShutdownTimeout(100*time.Millisecond),
+	)
+
+	err := s.Stop()
+	assert.True(t, errors.Is(err, ErrShutdownTimeout))
+	assert.EqualError(t, err, "pre-shutdown delay: shutdown timeout\n"+
+		"grpc drain: skipped after shutdown timeout\n"+
+		"http drain: skipped after shutdown timeout")
+}
+
+type deregisterFailingRegistry struct {
+	*StaticRegistry
+}
+
+func (deregisterFailingRegistry) Deregister(ctx context.Context, instance *ServiceInstance) error {
+	return errors.New("registry unavailable")
+}
+
+func TestStopErrors(t *testing.T) {
+	s := NewService(
+		PreShutdownDelay(1*time.Second),
+		ShutdownTimeout(100*time.Millisecond),
+		Registration(deregisterFailingRegistry{NewStaticRegistry()}, ServiceInstance{Name: "test"}),
+	)
+	s.registered = &ServiceInstance{Name: "test", ID: "test-1"}
+
+	// every failed phase is reported
+	err := s.Stop()
+	assert.True(t, errors.Is(err, ErrShutdownTimeout))
+	assert.EqualError(t, err, "deregister: registry unavailable\n"+
+		"pre-shutdown delay: shutdown timeout\n"+
+		"grpc drain: skipped after shutdown timeout\n"+
+		"http drain: skipped after shutdown timeout")
+}
+
+func TestStopGRPCDrainTimeout(t *testing.T) {
+	s := NewService(
+		PreShutdownDelay(0),
+		ShutdownTimeout(1*time.Second),
+	)
+	s.GRPCServer.RegisterService(&blockingServiceDesc, nil)
+
+	httpLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.RunWithListeners(ctx, httpLis, grpcLis, reverseProxyFunc)
+	}()
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	// open a long-lived stream which blocks the graceful stop
+	conn, err := grpc.Dial(grpcLis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stream, err := conn.NewStream(context.Background(), &blockingServiceDesc.Streams[0], "/micro.Blocking/Block")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, stream.SendMsg(&emptypb.Empty{}))
+
+	// the gRPC server is stopped abruptly once the shutdown timeout expires
+	start := time.Now()
+	cancel()
+	err = <-errChan
+	assert.True(t, errors.Is(err, ErrShutdownTimeout))
+	assert.EqualError(t, err, "grpc drain: shutdown timeout\nhttp drain: skipped after shutdown timeout")
+	assert.WithinDuration(t, start.Add(1*time.Second), time.Now(), 500*time.Millisecond)
+}
+
+func TestOnShutdown(t *testing.T) {
+	var order []string
+	called := false
+
+	s := NewService(
+		PreShutdownDelay(0),
+		ShutdownFunc(func() {
+			called = true
+		}),
+	)
+
+	errBoom := errors.New("boom")
+	s.OnShutdown("a", func(ctx context.Context) error {
+		order = append(order, "a")
+		return nil
+	})
+	s.OnShutdown("b", func(ctx context.Context) error {
+		order = append(order, "b")
+		return errBoom
+	})
+	s.OnShutdown("c", func(ctx context.Context) error {
+		order = append(order, "c")
+		return nil
+	})
+
+	err := s.Stop()
+	assert.True(t, called)
+	assert.Equal(t, []string{"c", "b", "a"}, order)
+	assert.True(t, errors.Is(err, errBoom))
+	assert.EqualError(t, err, "shutdown hook b: boom")
+}
+
+func TestOnShutdownTimeout(t *testing.T) {
+	s := NewService(
+		PreShutdownDelay(0),
+		ShutdownTimeout(100*time.Millisecond),
+	)
+
+	var order []string
+	s.OnShutdown("skipped", func(ctx context.Context) error {
+		order = append(order, "skipped")
+		return nil
+	})
+	s.OnShutdown("slow", func(ctx context.Context) error {
+		order = append(order, "slow")
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	})
+	s.OnShutdown("ctx", func(ctx context.Context) error {
+		order = append(order, "ctx")
+		return nil
+	})
+
+	// the hook which ignores the context overruns the timeout, the remaining hooks are skipped
+	err := s.Stop()
+	assert.Equal(t, []string{"ctx", "slow"}, order)
+	assert.True(t, errors.Is(err, ErrShutdownTimeout))
+	assert.EqualError(t, err, "shutdown hook slow: shutdown timeout\nshutdown hook skipped: skipped after shutdown timeout")
+}
+
+func TestOnShutdownContext(t *testing.T) {
+	s := NewService(
+		PreShutdownDelay(0),
+		ShutdownTimeout(100*time.Millisecond),
+	)
+
+	var order []string
+	s.OnShutdown("skipped", func(ctx context.Context) error {
+		order = append(order, "skipped")
+		return nil
+	})
+	s.OnShutdown("ctx", func(ctx context.Context) error {
+		order = append(order, "ctx")
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	// the hook is bounded by the context it receives
+	start := time.Now()
+	err := s.Stop()
+	assert.WithinDuration(t, start.Add(100*time.Millisecond), time.Now(), 100*time.Millisecond)
+	assert.Equal(t, []string{"ctx"}, order)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.EqualError(t, err, "shutdown hook ctx: context deadline exceeded\nshutdown hook skipped: skipped after shutdown timeout")
+}
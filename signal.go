@@ -0,0 +1,34 @@
+package micro
+
+import (
+	"context"
+	"os"
+	"os/signal"
+)
+
+// SignalContext - return a copy of the parent context which will be cancelled when one of the
+// signals is received, or when the returned cancel function is called, whichever happens first
+func SignalContext(parent context.Context, sigs ...os.Signal) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	// signal.Notify relays all incoming signals if no signal is provided
+	if len(sigs) == 0 {
+		return ctx, cancel
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, sigs...)
+
+	go func() {
+		defer signal.Stop(sigChan)
+
+		select {
+		case sig := <-sigChan:
+			GetLogger().Info("Interrupt signal received", "signal", sig.String())
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
@@ -0,0 +1,30 @@
+package micro
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignalContext(t *testing.T) {
+	ctx, cancel := SignalContext(context.Background(), syscall.SIGUSR1)
+	defer cancel()
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(3 * time.Second):
+		t.Error("context is not cancelled by the signal")
+	}
+}
+
+func TestSignalContextCancel(t *testing.T) {
+	ctx, cancel := SignalContext(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, ctx.Err())
+}
@@ -0,0 +1,208 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc/codes"
+)
+
+// StartSingle - start the microservice with gRPC and the HTTP gateway multiplexed on a single port,
+// gRPC requests are recognized by HTTP/2 with content-type application/grpc, plaintext HTTP/2 is
+// supported via h2c, it blocks until one of the interrupt signals is received
+func (s *Service) StartSingle(port uint16, reverseProxyFunc ReverseProxyFunc) error {
+	ctx, cancel := SignalContext(context.Background(), s.interruptSignals...)
+	defer cancel()
+
+	return s.RunSingle(ctx, port, reverseProxyFunc)
+}
+
+// StartSingleWithListener - start the microservice with gRPC and the HTTP gateway multiplexed on
+// the given listener, it blocks until one of the interrupt signals is received
+func (s *Service) StartSingleWithListener(lis net.Listener, reverseProxyFunc ReverseProxyFunc) error {
+	ctx, cancel := SignalContext(context.Background(), s.interruptSignals...)
+	defer cancel()
+
+	return s.RunSingleWithListener(ctx, lis, reverseProxyFunc)
+}
+
+// RunSingle - run the microservice with gRPC and the HTTP gateway multiplexed on a single port
+// until the context is cancelled, then the microservice will be stopped gracefully
+func (s *Service) RunSingle(ctx context.Context, port uint16, reverseProxyFunc ReverseProxyFunc) error {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+
+	return s.RunSingleWithListener(ctx, lis, reverseProxyFunc)
+}
+
+// RunSingleWithListener - run the microservice with gRPC and the HTTP gateway multiplexed on the
+// given listener until the context is cancelled, then the microservice will be stopped gracefully
+func (s *Service) RunSingleWithListener(ctx context.Context, lis net.Listener, reverseProxyFunc ReverseProxyFunc) error {
+	if s.tlsErr != nil {
+		return s.tlsErr
+	}
+	if s.certs != nil {
+		s.certs.start()
+		defer s.certs.stop()
+	}
+	s.health.start()
+	defer s.health.stop()
+
+	// channel to receive error
+	errChan := make(chan error, 1)
+
+	s.single = true
+	s.setAddrs(lis.Addr(), lis.Addr())
+
+	// announce the service before serving, so that it fails fast if the registry is unavailable
+	if err := s.register(ctx); err != nil {
+		return err
+	}
+
+	// start the multiplexed server
+	go func() {
+		GetLogger().Info("Starting gRPC and http server", "addr", lis.Addr().String())
+		errChan <- s.startSingle(lis, reverseProxyFunc)
+	}()
+
+	// wait for context cancellation
+	select {
+	// if the server fail to start
+	case err := <-errChan:
+		return err
+
+	// if the context is cancelled
+	case <-ctx.Done():
+		GetLogger().Info("Context done, stopping the service", "error", ctx.Err())
+		return s.Stop()
+	}
+}
+
+func (s *Service) startSingle(lis net.Listener, reverseProxyFunc ReverseProxyFunc) error {
+	s.registerGRPCServices()
+	s.serveInProcess()
+
+	// the gateway reverse-proxies to the same listener, the gRPC requests will be routed to GRPCServer
+	err := s.initGateway(dialTarget(lis.Addr()), reverseProxyFunc)
+	if err != nil {
+		return err
+	}
+
+	// the gRPC requests are served by the http server, so it has to be in HTTPS with TLS, which must
+	// be configured before http2 to advertise h2
+	https := s.configureHTTPS(true)
+
+	// configure the http server with the same http2 server used by h2c, so that the h2c
+	// connections will be notified with GOAWAY when the http server is shutting down
+	h2s := &http2.Server{}
+	if err := http2.ConfigureServer(s.HTTPServer, h2s); err != nil {
+		return err
+	}
+
+	s.HTTPServer.Addr = lis.Addr().String()
+	s.HTTPServer.Handler = h2c.NewHandler(s.grpcHandler(s.gatewayHandler()), h2s)
+
+	if https {
+		return s.HTTPServer.ServeTLS(lis, "", "")
+	}
+
+	return s.HTTPServer.Serve(lis)
+}
+
+// grpcHandler - route the gRPC requests to GRPCServer and the others to the given handler, the new
+// gRPC requests are rejected with UNAVAILABLE once the server is draining
+func (s *Service) grpcHandler(other http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			if !s.grpcRequests.add() {
+				// a trailers-only response with the status in the headers
+				w.Header().Set("Content-Type", "application/grpc")
+				w.Header().Set("Grpc-Status", strconv.Itoa(int(codes.Unavailable)))
+				w.Header().Set("Grpc-Message", "the server is shutting down")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			defer s.grpcRequests.done()
+
+			s.GRPCServer.ServeHTTP(w, r)
+			return
+		}
+
+		other.ServeHTTP(w, r)
+	})
+}
+
+// stopSingle - stop the multiplexed server gracefully, GRPCServer.GracefulStop can not be used
+// here because the gRPC requests are served by the http server
+func (s *Service) stopSingle(ctx context.Context) error {
+	// gracefully stop http server, the h2c connections will receive GOAWAY
+	err := s.stopHTTPServer(ctx)
+
+	// wait for the running gRPC requests to finish, the h2c connections are hijacked and not
+	// tracked by the http server, so the new requests on them are rejected from now on
+	drained := s.grpcRequests.drain()
+	err = errors.Join(err, waitPhase(ctx, PhaseGRPCDrain, func() { <-drained }))
+
+	s.GRPCServer.Stop()
+
+	return err
+}
+
+// inflightRequests - the number of the running gRPC requests of the multiplexed server, the new
+// requests are refused once it is draining
+type inflightRequests struct {
+	mu       sync.Mutex
+	count    int
+	stopping bool
+	drained  chan struct{}
+}
+
+// add - count a new request, false if it is draining
+func (r *inflightRequests) add() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stopping {
+		return false
+	}
+	r.count++
+
+	return true
+}
+
+// done - a request is finished
+func (r *inflightRequests) done() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.count--
+	if r.stopping && r.count == 0 {
+		close(r.drained)
+	}
+}
+
+// drain - refuse the new requests and return a channel which is closed when the running ones finish
+func (r *inflightRequests) drain() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.stopping {
+		r.stopping = true
+		r.drained = make(chan struct{})
+		if r.count == 0 {
+			close(r.drained)
+		}
+	}
+
+	return r.drained
+}
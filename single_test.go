@@ -0,0 +1,123 @@
+package micro
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestStartSingle(t *testing.T) {
+	s := NewService(
+		PreShutdownDelay(0),
+		ShutdownTimeout(5*time.Second),
+	)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.StartSingleWithListener(lis, reverseProxyFunc)
+	}()
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	assert.Equal(t, lis.Addr(), s.HTTPAddr())
+	assert.Equal(t, lis.Addr(), s.GRPCAddr())
+
+	// the http endpoint is served over HTTP/1
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", lis.Addr()))
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Len(t, resp.Header.Get("X-Request-Id"), 36)
+		resp.Body.Close()
+	}
+
+	// the gRPC request is routed to the gRPC server on the same port
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if assert.NoError(t, err) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		err = conn.Invoke(ctx, "/micro.Test/Ping", &emptypb.Empty{}, &emptypb.Empty{})
+		cancel()
+		assert.Equal(t, codes.Unimplemented, status.Code(err))
+		conn.Close()
+	}
+
+	s.Stop()
+	assert.Equal(t, http.ErrServerClosed, <-errChan)
+}
+
+func TestSingleDraining(t *testing.T) {
+	s := NewService()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.startSingle(lis, reverseProxyFunc)
+	defer s.HTTPServer.Close()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	invoke := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		return conn.Invoke(ctx, "/micro.Test/Ping", &emptypb.Empty{}, &emptypb.Empty{})
+	}
+	assert.Equal(t, codes.Unimplemented, status.Code(invoke()))
+
+	// the new requests on the open connections are rejected once draining starts
+	drained := s.grpcRequests.drain()
+	<-drained
+	err = invoke()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, "the server is shutting down", status.Convert(err).Message())
+}
+
+func TestInflightRequests(t *testing.T) {
+	var r inflightRequests
+	assert.True(t, r.add())
+	assert.True(t, r.add())
+	r.done()
+
+	drained := r.drain()
+	assert.False(t, r.add())
+	select {
+	case <-drained:
+		t.Fatal("drained with a running request")
+	default:
+	}
+
+	r.done()
+	<-drained
+	assert.Equal(t, drained, r.drain())
+}
+
+func TestStartSingleError(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	// the port is already in use
+	s := NewService()
+	err = s.StartSingle(uint16(lis.Addr().(*net.TCPAddr).Port), reverseProxyFunc)
+	assert.Error(t, err)
+}
@@ -0,0 +1,286 @@
+package micro
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// TLSOpts - the options of the TLS of the service, the files are watched and the rotated
+// certificates are used by the new connections without restarting the service
+type TLSOpts struct {
+	// the PEM file of the certificate chain of the service
+	CertFile string
+	// the PEM file of the private key of the service
+	KeyFile string
+	// the PEM file of the CA certificates, the peers have to present the certificates signed by them
+	// (mTLS) if it is set, the system roots are used to verify the gRPC server otherwise. As the
+	// gateway presents the certificate of the service to the gRPC server, the certificate needs both
+	// the serverAuth and the clientAuth extended key usages if it is set
+	CAFile string
+	// the name to verify the certificate of the gRPC server dialed by the gateway, the host of the
+	// dial target by default
+	ServerName string
+	// serve the gateway in HTTPS with the same certificate, the client certificates are required if
+	// CAFile is set. It is always served in HTTPS by RunSingle as gRPC shares the port
+	HTTPS bool
+	// how often the files are checked for the rotation
+	ReloadInterval time.Duration
+}
+
+// NewTLSOpts - create the TLS options of the files which are checked every 10 seconds, the CA file
+// can be empty to disable the client certificate verification
+func NewTLSOpts(certFile string, keyFile string, caFile string) *TLSOpts {
+	return &TLSOpts{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		CAFile:         caFile,
+		ReloadInterval: 10 * time.Second,
+	}
+}
+
+// certReloader - the certificates loaded from the files of the TLS options
+type certReloader struct {
+	opts *TLSOpts
+
+	mu    sync.RWMutex
+	cert  *tls.Certificate
+	pool  *x509.CertPool
+	files [][]byte
+
+	done      chan struct{}
+	stopped   chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+func newCertReloader(opts *TLSOpts) (*certReloader, error) {
+	r := &certReloader{opts: opts, done: make(chan struct{})}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// reload - load the files if they are changed, the current certificates are kept if it fails
+func (r *certReloader) reload() error {
+	paths := []string{r.opts.CertFile, r.opts.KeyFile}
+	if r.opts.CAFile != "" {
+		paths = append(paths, r.opts.CAFile)
+	}
+
+	files := make([][]byte, len(paths))
+	for i, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[i] = data
+	}
+
+	r.mu.RLock()
+	unchanged := sameFiles(r.files, files)
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cert, err := tls.X509KeyPair(files[0], files[1])
+	if err != nil {
+		return fmt.Errorf("tls: %s: %w", r.opts.CertFile, err)
+	}
+
+	var pool *x509.CertPool
+	if r.opts.CAFile != "" {
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(files[2]) {
+			return fmt.Errorf("tls: %s: no CA certificates", r.opts.CAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert, r.pool, r.files = &cert, pool, files
+	r.mu.Unlock()
+
+	GetLogger().Info("Loaded TLS certificates", "cert", r.opts.CertFile, "ca", r.opts.CAFile)
+	return nil
+}
+
+func sameFiles(a [][]byte, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// watch - reload the files every interval until it is stopped
+func (r *certReloader) watch() {
+	ticker := time.NewTicker(r.opts.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			if err := r.reload(); err != nil {
+				GetLogger().Warn("Failed to reload the TLS certificates", "error", err)
+			}
+		}
+	}
+}
+
+// start - watch the files in background once
+func (r *certReloader) start() {
+	r.startOnce.Do(func() {
+		r.stopped = make(chan struct{})
+		go func() {
+			defer close(r.stopped)
+			r.watch()
+		}()
+	})
+}
+
+// stop - stop watching the files and wait for the running reload, it can not be started again
+func (r *certReloader) stop() {
+	r.stopOnce.Do(func() {
+		close(r.done)
+	})
+
+	r.startOnce.Do(func() {})
+	if r.stopped != nil {
+		<-r.stopped
+	}
+}
+
+func (r *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, r.pool
+}
+
+// serverConfig - the TLS config of the servers, the client certificates are required and verified
+// by the current CA certificates if the CA file is set
+func (r *certReloader) serverConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+	}
+
+	if r.opts.CAFile != "" {
+		// the chains are verified by VerifyPeerCertificate to use the reloaded CA certificates
+		config.ClientAuth = tls.RequireAnyClientCert
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			_, pool := r.current()
+			return verifyChain(rawCerts, x509.VerifyOptions{
+				Roots:     pool,
+				KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			})
+		}
+	}
+
+	return config
+}
+
+// clientConfig - the TLS config of the gateway to dial the gRPC server with the certificate of the
+// service, the server is verified by the current CA certificates
+func (r *certReloader) clientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+		// the chain is verified by VerifyConnection to use the reloaded CA certificates
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			serverName := r.opts.ServerName
+			if serverName == "" {
+				serverName = cs.ServerName
+			}
+
+			rawCerts := make([][]byte, len(cs.PeerCertificates))
+			for i, cert := range cs.PeerCertificates {
+				rawCerts[i] = cert.Raw
+			}
+
+			_, pool := r.current()
+			return verifyChain(rawCerts, x509.VerifyOptions{
+				Roots:     pool,
+				DNSName:   serverName,
+				KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			})
+		},
+	}
+}
+
+// verifyChain - verify the peer certificate with the intermediates in the chain
+func verifyChain(rawCerts [][]byte, opts x509.VerifyOptions) error {
+	if len(rawCerts) == 0 {
+		return errors.New("tls: no peer certificate")
+	}
+
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+
+	opts.Intermediates = x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+// initTLS - load the certificates and configure the credentials of the gRPC server and the gateway,
+// the error is returned when the service is run, and the files are watched while it is running
+func (s *Service) initTLS() {
+	certs, err := newCertReloader(s.tlsOpts)
+	if err != nil {
+		GetLogger().Error("Failed to load the TLS certificates", "error", err)
+		s.tlsErr = err
+		return
+	}
+
+	s.certs = certs
+	s.grpcCredentials = credentials.NewTLS(certs.serverConfig())
+	// only the gateway dials with the certificate of the service, the clients created by Dial are
+	// configured by ClientTLS
+	s.gatewayDialOptions = append(s.gatewayDialOptions, grpc.WithTransportCredentials(credentials.NewTLS(certs.clientConfig())))
+}
+
+// configureHTTPS - set the TLS config of the http server if the gateway is served in HTTPS or it is
+// forced, e.g. gRPC shares the port
+func (s *Service) configureHTTPS(force bool) bool {
+	if s.certs == nil || !(force || s.tlsOpts.HTTPS) {
+		return false
+	}
+
+	s.HTTPServer.TLSConfig = s.certs.serverConfig()
+	return true
+}
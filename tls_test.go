@@ -0,0 +1,294 @@
+package micro
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// testCA - a CA to issue the certificates of the tests
+type testCA struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	return &testCA{key: key, cert: cert, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue - issue a certificate of localhost for both the server and the client in PEM
+func (ca *testCA) issue(t *testing.T, serial int64) (certPEM []byte, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// clientConfig - the TLS config of a client verifying the server by the CA
+func (ca *testCA) clientConfig(t *testing.T, withCert bool) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.pem)
+	config := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+
+	if withCert {
+		cert, err := tls.X509KeyPair(ca.issue(t, 100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestTLSFiles - write the certificate of the serial, its key and the CA to the directory
+func writeTestTLSFiles(t *testing.T, dir string, ca *testCA, serial int64) *TLSOpts {
+	certPEM, keyPEM := ca.issue(t, serial)
+	opts := NewTLSOpts(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt"))
+	writeFile(t, opts.CertFile, certPEM)
+	writeFile(t, opts.KeyFile, keyPEM)
+	writeFile(t, opts.CAFile, ca.pem)
+
+	return opts
+}
+
+// servedSerial - the serial number of the certificate served on the address
+func servedSerial(t *testing.T, addr string, config *tls.Config) int64 {
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCA(t)
+	opts := writeTestTLSFiles(t, t.TempDir(), ca, 1)
+	s := NewService(TLS(opts))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.startGRPCServer(lis)
+	t.Cleanup(s.GRPCServer.Stop)
+	addr := lis.Addr().String()
+
+	// mTLS
+	assert.NoError(t, checkHealth(t, addr, ClientTLS(ca.clientConfig(t, true))))
+	assert.Error(t, checkHealth(t, addr, ClientTLS(ca.clientConfig(t, false))))
+	assert.Error(t, checkHealth(t, addr, ClientTLS(newTestCA(t).clientConfig(t, true))))
+
+	// the gateway dials with the certificate of the service
+	if err := s.initGateway(addr, healthReverseProxyFunc("health")); err != nil {
+		t.Fatal(err)
+	}
+	resp := httptest.NewRecorder()
+	s.gatewayHandler().ServeHTTP(resp, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, "SERVING", resp.Body.String())
+
+	config := ca.clientConfig(t, true)
+	config.NextProtos = []string{"h2"}
+	assert.Equal(t, int64(1), servedSerial(t, addr, config))
+
+	// the rotated certificate is served by the new connections
+	certPEM, keyPEM := ca.issue(t, 2)
+	writeFile(t, opts.CertFile, certPEM)
+	writeFile(t, opts.KeyFile, keyPEM)
+	assert.NoError(t, s.certs.reload())
+	assert.Equal(t, int64(2), servedSerial(t, addr, config))
+
+	// the current certificate is kept if the files are invalid
+	writeFile(t, opts.KeyFile, []byte("invalid"))
+	assert.Error(t, s.certs.reload())
+	assert.Equal(t, int64(2), servedSerial(t, addr, config))
+}
+
+func TestTLSServiceDial(t *testing.T) {
+	ca := newTestCA(t)
+	opts := writeTestTLSFiles(t, t.TempDir(), ca, 1)
+	opts.ServerName = "micro.internal"
+	s := NewService(TLS(opts))
+
+	// the other services are dialed without the certificate and the server name of the service
+	addr, _ := startTestGRPCServer(t)
+	conn, err := s.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+}
+
+func TestTLSWatch(t *testing.T) {
+	ca := newTestCA(t)
+	opts := writeTestTLSFiles(t, t.TempDir(), ca, 1)
+	opts.ReloadInterval = 10 * time.Millisecond
+
+	r, err := newCertReloader(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.start()
+	defer r.stop()
+
+	writeTestTLSFiles(t, filepath.Dir(opts.CertFile), ca, 2)
+	assert.Eventually(t, func() bool {
+		cert, _ := r.current()
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		return leaf.SerialNumber.Int64() == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTLSWatchRunning(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	opts := writeTestTLSFiles(t, dir, ca, 1)
+	opts.ReloadInterval = 10 * time.Millisecond
+	s := NewService(TLS(opts))
+	serial := func() int64 {
+		cert, _ := s.certs.current()
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		return leaf.SerialNumber.Int64()
+	}
+
+	// the files are not watched before the service is run
+	writeTestTLSFiles(t, dir, ca, 2)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(1), serial())
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- s.RunSingleWithListener(ctx, lis, reverseProxyFunc)
+	}()
+
+	assert.Eventually(t, func() bool { return serial() == 2 }, time.Second, 10*time.Millisecond)
+
+	// the files are not watched after the service is stopped
+	cancel()
+	<-done
+	writeTestTLSFiles(t, dir, ca, 3)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(2), serial())
+}
+
+func TestTLSError(t *testing.T) {
+	s := NewService(TLS(NewTLSOpts("missing.crt", "missing.key", "")))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	err = s.RunSingleWithListener(context.Background(), lis, reverseProxyFunc)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestTLSSingle(t *testing.T) {
+	ca := newTestCA(t)
+	s := NewService(TLS(writeTestTLSFiles(t, t.TempDir(), ca, 1)))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- s.RunSingleWithListener(ctx, lis, healthReverseProxyFunc("health"))
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	addr := lis.Addr().String()
+
+	// gRPC and the gateway share the port in HTTPS
+	assert.Eventually(t, func() bool {
+		return checkHealth(t, addr, ClientTLS(ca.clientConfig(t, true))) == nil
+	}, time.Second, 10*time.Millisecond)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: ca.clientConfig(t, true)}}
+	resp, err := client.Get("https://" + addr + "/health")
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "SERVING", string(body))
+	}
+
+	_, err = (&http.Client{Transport: &http.Transport{TLSClientConfig: ca.clientConfig(t, false)}}).Get("https://" + addr + "/health")
+	assert.Error(t, err)
+}
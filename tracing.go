@@ -1,17 +1,33 @@
 package micro
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
 	jaeger "github.com/uber/jaeger-client-go"
 	"github.com/uber/jaeger-client-go/config"
+	"github.com/uber/jaeger-client-go/thrift-gen/sampling"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
-// InitSpan - initiate the tracing span and set the http response header with X-Request-Id
+// InitSpan - initiate the tracing span and set the http response header with X-Request-Id, the
+// span is named with the route template of the google.api.http options of the registered services,
+// or the one matched by the mux, e.g. "GET /v1/users/{id}", and tagged with the status code and size
+// of the response
 func InitSpan(mux *runtime.ServeMux) http.Handler {
+	return initSpan(mux, newRouteResolver(protoregistry.GlobalFiles), nil)
+}
+
+func initSpan(mux *runtime.ServeMux, resolver *routeResolver, p propagation.TextMapPropagator) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var serverSpan opentracing.Span
 
@@ -20,41 +36,45 @@ func InitSpan(mux *runtime.ServeMux) http.Handler {
 		// By default, Jaeger use "uber-trace-id" to propagate tracing context,
 		// and use prefix "uberctx-" to propagate baggage in http headers.
 		// See https://github.com/jaegertracing/jaeger-client-go/blob/master/constants.go
-		var wireContext, err = opentracing.GlobalTracer().Extract(
+		var wireContext, err = tracerWithPropagator(opentracing.GlobalTracer(), p).Extract(
 			opentracing.HTTPHeaders,
 			opentracing.HTTPHeadersCarrier(r.Header),
 		)
-		// We will be using method name as the span name (method above)
+		// We will be using method name as the span name (method above), the route template is
+		// resolved before the span is started so that the sampler decides by it, the span is
+		// renamed if the mux matches a route unknown to the resolver
 		var methodName = r.Method + " " + r.URL.Path
+		if template := resolver.resolve(r); template != "" {
+			methodName = r.Method + " " + template
+		}
+		// the sensitive headers and query parameters are never written into the logs and span tags
+		policy := GetRedactionPolicy()
 		if err != nil {
 			// Found no span in headers, start a new span as root span
-			Logger().Infof(err.Error())
-			for k, h := range r.Header {
-				for _, v := range h {
-					Logger().Infof("Header: %s - %s", k, v)
-				}
-			}
+			GetLogger().Debug("No parent span found, start a root span",
+				"method", r.Method, "path", r.URL.Path, "error", err, "headers", policy.Headers(r.Header))
 			serverSpan = opentracing.StartSpan(methodName)
 		} else {
 			// Create span as a child of parent context
-			Logger().Infof("Found parent span, start a child span: " + methodName)
+			GetLogger().Debug("Found parent span, start a child span", "method", r.Method, "path", r.URL.Path)
 			serverSpan = opentracing.StartSpan(
 				methodName,
 				opentracing.ChildOf(wireContext),
 			)
 		}
+		ext.HTTPMethod.Set(serverSpan, r.Method)
 		serverSpan.SetTag("http.url.host", r.URL.Hostname())
 		serverSpan.SetTag("peer.address", r.RemoteAddr)
-		serverSpan.SetTag("http.url", r.URL.RequestURI())
-		serverSpan.SetTag("http.url.query", r.URL.RawQuery)
+		serverSpan.SetTag("http.url", policy.RequestURI(r.URL))
+		serverSpan.SetTag("http.url.query", policy.Query(r.URL.RawQuery))
 
 		var footprint string
 		if footprint = serverSpan.BaggageItem("footprint"); footprint != "" {
-			Logger().Infof("Found baggage item footprint in span: " + footprint)
+			GetLogger().Debug("Found baggage item footprint in span", "request_id", footprint)
 			serverSpan.SetTag("footprint", footprint)
 		} else {
 			footprint = RequestID(r)
-			Logger().Infof("No baggage item footprint found in span, try to get from X-Request-Id: " + footprint)
+			GetLogger().Debug("No baggage item footprint found in span, use X-Request-Id", "request_id", footprint)
 			serverSpan.SetBaggageItem("footprint", footprint)
 			serverSpan.SetTag("footprint", footprint)
 		}
@@ -63,35 +83,185 @@ func InitSpan(mux *runtime.ServeMux) http.Handler {
 		w.Header().Set("X-Request-Id", footprint)
 
 		// We are passing the span as an item in Go context
-		Logger().Infof("Passing span into context: %+v", serverSpan)
+		GetLogger().Debug("Passing span into context",
+			"method", r.Method, "path", r.URL.Path, "request_id", footprint, "span", fmt.Sprintf("%+v", serverSpan))
 		var ctx = opentracing.ContextWithSpan(r.Context(), serverSpan)
 
-		mux.ServeHTTP(w, r.WithContext(ctx))
+		// We are passing the logger with the request info as well
+		keyvals := []interface{}{"request_id", footprint}
+		if traceID, spanID := SpanIDs(ctx); traceID != "" {
+			keyvals = append(keyvals, "trace_id", traceID, "span_id", spanID)
+		}
+		keyvals = append(keyvals, "method", r.Method, "path", r.URL.Path)
+		ctx = ContextWithLogger(ctx, GetLogger().With(keyvals...))
+		ctx = contextWithRequestID(ctx, footprint)
+
+		ctx, route := contextWithHTTPRoute(ctx)
+		recorder := newResponseRecorder(w)
+		mux.ServeHTTP(recorder, r.WithContext(ctx))
+
+		if route.template != "" {
+			serverSpan.SetOperationName(r.Method + " " + route.template)
+			serverSpan.SetTag("http.route", route.template)
+		}
+		ext.HTTPStatusCode.Set(serverSpan, uint16(recorder.Status()))
+		serverSpan.SetTag("http.response_size", recorder.Size())
+		if recorder.Status() >= http.StatusInternalServerError {
+			ext.Error.Set(serverSpan, true)
+		}
 
 		// Span needs to be finished in order to report it to Jaeger collector
 		serverSpan.Finish()
 	})
 }
 
-// InitJaeger - helper to initiate an instance of Jaeger Tracer as global tracer, if you need to
-// customize your tracer, you can do it yourself instead of calling this function
+// SpanIDs - get the trace id and span id of the OpenTelemetry span or the OpenTracing span in the
+// context, empty strings are returned if there is no span or the span is not created by the jaeger tracer
+func SpanIDs(ctx context.Context) (traceID string, spanID string) {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		return sc.TraceID().String(), sc.SpanID().String()
+	}
+
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return "", ""
+	}
+
+	if sc, ok := span.Context().(jaeger.SpanContext); ok {
+		return sc.TraceID().String(), sc.SpanID().String()
+	}
+
+	return "", ""
+}
+
+// SamplerType - the type of the sampler which decides if a trace is recorded
+type SamplerType string
+
+const (
+	// SamplerConst - sample all the traces if the param is 1, or none if it is 0
+	SamplerConst SamplerType = jaeger.SamplerTypeConst
+	// SamplerProbabilistic - sample the traces with the probability of the param, between 0 and 1
+	SamplerProbabilistic SamplerType = jaeger.SamplerTypeProbabilistic
+	// SamplerRateLimiting - sample at most the param number of traces per second
+	SamplerRateLimiting SamplerType = jaeger.SamplerTypeRateLimiting
+	// SamplerRemote - poll the sampling strategies from the sampling server, e.g. the jaeger agent,
+	// the param is the probability before the strategies are fetched
+	SamplerRemote SamplerType = jaeger.SamplerTypeRemote
+	// SamplerPerOperation - sample the traces with the probability of OperationSamplerParams by the
+	// operation name, e.g. "GET /v1/users" or "/pkg.Service/Method", the param is the probability
+	// of the other operations
+	SamplerPerOperation SamplerType = "peroperation"
+)
+
+// JaegerOpts - the options of InitJaegerWithOpts
+type JaegerOpts struct {
+	// the service name of the spans
+	ServiceName string
+	// the host and port of the jaeger agent
+	LocalAgentHostPort string
+	// the type of the sampler, all the traces are sampled by default
+	Sampler SamplerType
+	// the param of the sampler, see SamplerType
+	SamplerParam float64
+	// the url of the sampling server of SamplerRemote, e.g. http://localhost:5778/sampling
+	SamplingServerURL string
+	// how often SamplerRemote polls the sampling server, 1 minute by default
+	SamplingRefreshInterval time.Duration
+	// the maximum number of operations tracked by SamplerRemote and SamplerPerOperation, 2000 by default
+	MaxOperations int
+	// the sampling probabilities by operation name of SamplerPerOperation
+	OperationSamplerParams map[string]float64
+	// the traces per second sampled by SamplerPerOperation for each operation regardless of the
+	// probability, the first trace of each operation is always sampled
+	LowerBoundTracesPerSecond float64
+	// the request header to force sampling of the trace, e.g. "jaeger-debug-id: some-correlation-id"
+	// can be sent by curl to always trace the request, "jaeger-debug-id" by default
+	DebugHeader string
+	// log the spans and the tracer errors to stdout, otherwise they are discarded
+	Debug bool
+}
+
+// InitJaeger - helper to initiate an instance of Jaeger Tracer as global tracer which samples all
+// the traces, if you need to customize your tracer, you can do it yourself instead of calling this
+// function, or see InitJaegerWithOpts to configure the sampling
 func InitJaeger(service, samplingServerURL, localAgentHost string, debug bool) (io.Closer, error) {
+	return InitJaegerWithOpts(JaegerOpts{
+		ServiceName:        service,
+		LocalAgentHostPort: localAgentHost,
+		SamplingServerURL:  samplingServerURL,
+		Debug:              debug,
+	})
+}
+
+// InitJaegerWithOpts - helper to initiate an instance of Jaeger Tracer as global tracer with the
+// sampling configuration of the options
+func InitJaegerWithOpts(opts JaegerOpts) (io.Closer, error) {
+	cfg, options, err := jaegerConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	l := config.Logger(jaeger.NullLogger)
+	if opts.Debug { // only log to stdout in debug mode
+		l = config.Logger(jaeger.StdLogger)
+	}
+	options = append(options, l, config.ZipkinSharedRPCSpan(true))
+
+	return cfg.InitGlobalTracer(opts.ServiceName, options...)
+}
+
+// jaegerConfig - convert the options into the jaeger configuration, the sampler of
+// SamplerPerOperation is not supported by the configuration and is returned as an option
+func jaegerConfig(opts JaegerOpts) (*config.Configuration, []config.Option, error) {
 	cfg := &config.Configuration{
+		ServiceName: opts.ServiceName,
 		Sampler: &config.SamplerConfig{
-			Type:              jaeger.SamplerTypeConst,
-			Param:             1,
-			SamplingServerURL: samplingServerURL,
+			Type:                    string(opts.Sampler),
+			Param:                   opts.SamplerParam,
+			SamplingServerURL:       opts.SamplingServerURL,
+			SamplingRefreshInterval: opts.SamplingRefreshInterval,
+			MaxOperations:           opts.MaxOperations,
 		},
 		Reporter: &config.ReporterConfig{
 			LogSpans:           true,
-			LocalAgentHostPort: localAgentHost,
+			LocalAgentHostPort: opts.LocalAgentHostPort,
+		},
+		Headers: &jaeger.HeadersConfig{
+			// the keys of gRPC metadata are lower case
+			JaegerDebugHeader: strings.ToLower(opts.DebugHeader),
 		},
 	}
 
-	l := config.Logger(jaeger.NullLogger)
-	if debug { // only log to stdout in debug mode
-		l = config.Logger(jaeger.StdLogger)
+	var options []config.Option
+	switch opts.Sampler {
+	case "":
+		cfg.Sampler.Type = jaeger.SamplerTypeConst
+		cfg.Sampler.Param = 1
+	case SamplerPerOperation:
+		strategies := &sampling.PerOperationSamplingStrategies{
+			DefaultSamplingProbability:       opts.SamplerParam,
+			DefaultLowerBoundTracesPerSecond: opts.LowerBoundTracesPerSecond,
+		}
+		for operation, param := range opts.OperationSamplerParams {
+			if param < 0 || param > 1 {
+				return nil, nil, fmt.Errorf("jaeger: invalid sampling probability %v of operation %s", param, operation)
+			}
+			strategies.PerOperationStrategies = append(strategies.PerOperationStrategies, &sampling.OperationSamplingStrategy{
+				Operation:             operation,
+				ProbabilisticSampling: &sampling.ProbabilisticSamplingStrategy{SamplingRate: param},
+			})
+		}
+
+		maxOperations := opts.MaxOperations
+		if maxOperations == 0 {
+			maxOperations = 2000
+		}
+		sampler, err := jaeger.NewAdaptiveSampler(strategies, maxOperations)
+		if err != nil {
+			return nil, nil, err
+		}
+		options = append(options, config.Sampler(sampler))
 	}
 
-	return cfg.InitGlobalTracer(service, l, config.ZipkinSharedRPCSpan(true))
+	return cfg, options, nil
 }
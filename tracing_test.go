@@ -1,9 +1,22 @@
 package micro
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/stretchr/testify/assert"
+	jaeger "github.com/uber/jaeger-client-go"
+	"github.com/uber/jaeger-client-go/config"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
 )
 
 func TestInitJaeger(t *testing.T) {
@@ -11,3 +24,266 @@ func TestInitJaeger(t *testing.T) {
 	assert.Nil(t, closer)
 	assert.Error(t, err)
 }
+
+func TestInitSpanContextLogger(t *testing.T) {
+	jl := &recordingJaegerLogger{}
+	defer SetStructuredLogger(GetLogger())
+	SetStructuredLogger(NewJaegerLogger(jl))
+
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+	defer opentracing.SetGlobalTracer(opentracing.GlobalTracer())
+	opentracing.SetGlobalTracer(tracer)
+
+	var traceID, spanID string
+	mux := runtime.NewServeMux()
+	mux.Handle("GET", PathPattern("test"), func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		traceID, spanID = SpanIDs(r.Context())
+		LoggerFromContext(r.Context()).Info("hello")
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-Request-Id", "uuid")
+	InitSpan(mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.NotEmpty(t, traceID)
+	assert.Contains(t, jl.lines, fmt.Sprintf("INFO hello request_id=uuid trace_id=%s span_id=%s method=GET path=/test", traceID, spanID))
+}
+
+// newTestJaegerTracer - create the tracer of the options which reports nothing
+func newTestJaegerTracer(t *testing.T, opts JaegerOpts) opentracing.Tracer {
+	cfg, options, err := jaegerConfig(opts)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	tracer, closer, err := cfg.NewTracer(append(options, config.Reporter(jaeger.NewNullReporter()))...)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { closer.Close() })
+
+	return tracer
+}
+
+func isSampled(span opentracing.Span) bool {
+	return span.Context().(jaeger.SpanContext).IsSampled()
+}
+
+func TestInitJaegerWithOpts(t *testing.T) {
+	closer, err := InitJaegerWithOpts(JaegerOpts{ServiceName: "test", Sampler: "unknown"})
+	assert.Nil(t, closer)
+	assert.Error(t, err)
+
+	closer, err = InitJaegerWithOpts(JaegerOpts{
+		ServiceName:            "test",
+		Sampler:                SamplerPerOperation,
+		OperationSamplerParams: map[string]float64{"GET /test": 2},
+	})
+	assert.Nil(t, closer)
+	assert.EqualError(t, err, "jaeger: invalid sampling probability 2 of operation GET /test")
+}
+
+func TestJaegerSampler(t *testing.T) {
+	tracer := newTestJaegerTracer(t, JaegerOpts{ServiceName: "test"})
+	assert.True(t, isSampled(tracer.StartSpan("GET /test")))
+
+	tracer = newTestJaegerTracer(t, JaegerOpts{ServiceName: "test", Sampler: SamplerProbabilistic, SamplerParam: 0})
+	assert.False(t, isSampled(tracer.StartSpan("GET /test")))
+
+	tracer = newTestJaegerTracer(t, JaegerOpts{
+		ServiceName:            "test",
+		Sampler:                SamplerPerOperation,
+		SamplerParam:           0,
+		OperationSamplerParams: map[string]float64{"GET /test": 1},
+	})
+	assert.True(t, isSampled(tracer.StartSpan("GET /test")))
+	// the first trace of the operation is sampled by the lower bound
+	assert.True(t, isSampled(tracer.StartSpan("GET /other")))
+	assert.False(t, isSampled(tracer.StartSpan("GET /other")))
+}
+
+func TestJaegerDebugHeader(t *testing.T) {
+	tracer := newTestJaegerTracer(t, JaegerOpts{
+		ServiceName:  "test",
+		Sampler:      SamplerProbabilistic,
+		SamplerParam: 0,
+		DebugHeader:  "X-Debug-Id",
+	})
+	defer opentracing.SetGlobalTracer(opentracing.GlobalTracer())
+	opentracing.SetGlobalTracer(tracer)
+
+	var sampled bool
+	mux := runtime.NewServeMux()
+	mux.Handle("GET", PathPattern("test"), func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		sampled = isSampled(opentracing.SpanFromContext(r.Context()))
+	})
+
+	InitSpan(mux).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+	assert.False(t, sampled)
+
+	// the request with the debug header is always sampled
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-Debug-Id", "debug")
+	InitSpan(mux).ServeHTTP(httptest.NewRecorder(), req)
+	assert.True(t, sampled)
+}
+
+// newRouteTestMux - create the mux which serves "/v1/users/{id}" like the generated gateway handler
+func newRouteTestMux(code int) *runtime.ServeMux {
+	mux := runtime.NewServeMux(runtime.WithMetadata(HTTPRouteAnnotator))
+	pattern := runtime.MustPattern(runtime.NewPattern(1,
+		[]int{2, 0, 2, 1, 1, 0, 4, 1, 5, 2}, []string{"v1", "users", "id"}, ""))
+	mux.Handle("GET", pattern, func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		_, err := runtime.AnnotateContext(r.Context(), mux, r, "/pb.Users/Get",
+			runtime.WithHTTPPathPattern("/v1/users/{id}"))
+		if err != nil {
+			panic(err)
+		}
+		w.WriteHeader(code)
+		w.Write([]byte("body"))
+	})
+
+	return mux
+}
+
+func TestInitSpanRoute(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+	defer opentracing.SetGlobalTracer(opentracing.GlobalTracer())
+	opentracing.SetGlobalTracer(tracer)
+
+	InitSpan(newRouteTestMux(http.StatusOK)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/users/123", nil))
+	InitSpan(newRouteTestMux(http.StatusBadGateway)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/users/456", nil))
+	InitSpan(newRouteTestMux(http.StatusOK)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/unknown", nil))
+
+	spans := reporter.GetSpans()
+	if !assert.Len(t, spans, 3) {
+		return
+	}
+
+	span := spans[0].(*jaeger.Span)
+	assert.Equal(t, "GET /v1/users/{id}", span.OperationName())
+	assert.Equal(t, "/v1/users/{id}", span.Tags()["http.route"])
+	assert.Equal(t, uint16(http.StatusOK), span.Tags()["http.status_code"])
+	assert.Equal(t, 4, span.Tags()["http.response_size"])
+	assert.Nil(t, span.Tags()["error"])
+
+	span = spans[1].(*jaeger.Span)
+	assert.Equal(t, "GET /v1/users/{id}", span.OperationName())
+	assert.Equal(t, uint16(http.StatusBadGateway), span.Tags()["http.status_code"])
+	assert.Equal(t, true, span.Tags()["error"])
+
+	// the literal path is kept if the mux matches nothing
+	span = spans[2].(*jaeger.Span)
+	assert.Equal(t, "GET /unknown", span.OperationName())
+	assert.Equal(t, uint16(http.StatusNotFound), span.Tags()["http.status_code"])
+}
+
+// newHTTPRuleFiles - the files of the test service whose method has the http rule "/v1/users/{id}"
+func newHTTPRuleFiles(t *testing.T) *protoregistry.Files {
+	options := &descriptorpb.MethodOptions{}
+	proto.SetExtension(options, annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{Get: "/v1/users/{id}"},
+		AdditionalBindings: []*annotations.HttpRule{
+			{Pattern: &annotations.HttpRule_Custom{Custom: &annotations.CustomHttpPattern{Kind: "HEAD", Path: "/v1/users/{id}"}}},
+		},
+	})
+
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("users.proto"),
+		Package:    proto.String("users"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/empty.proto"},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Users"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{Name: proto.String("GetUser"), InputType: proto.String(".google.protobuf.Empty"), OutputType: proto.String(".google.protobuf.Empty"), Options: options},
+			},
+		}},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := &protoregistry.Files{}
+	if err := files.RegisterFile(file); err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func TestRouteResolver(t *testing.T) {
+	resolver := newRouteResolver(newHTTPRuleFiles(t))
+
+	assert.Equal(t, "/v1/users/{id}", resolver.resolve(httptest.NewRequest("GET", "/v1/users/123", nil)))
+	assert.Equal(t, "/v1/users/{id}", resolver.resolve(httptest.NewRequest("HEAD", "/v1/users/123", nil)))
+	assert.Equal(t, "", resolver.resolve(httptest.NewRequest("POST", "/v1/users/123", nil)))
+	assert.Equal(t, "", resolver.resolve(httptest.NewRequest("GET", "/v1/users", nil)))
+
+	// the POST requests of a form fall back to GET
+	for i := 0; i < 10; i++ {
+		req := httptest.NewRequest("POST", "/v1/users/123", strings.NewReader("fields=name"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		assert.Equal(t, "/v1/users/{id}", resolver.resolve(req))
+	}
+}
+
+func TestInitSpanPerOperationSampler(t *testing.T) {
+	// the templated route is never sampled except the first trace by the lower bound, the other
+	// operations are always sampled
+	tracer := newTestJaegerTracer(t, JaegerOpts{
+		ServiceName:            "test",
+		Sampler:                SamplerPerOperation,
+		SamplerParam:           1,
+		OperationSamplerParams: map[string]float64{"GET /v1/users/{id}": 0},
+	})
+	defer opentracing.SetGlobalTracer(opentracing.GlobalTracer())
+	opentracing.SetGlobalTracer(tracer)
+
+	var operations []string
+	var sampled []bool
+	mux := runtime.NewServeMux()
+	mux.Handle("GET", runtime.MustPattern(runtime.NewPattern(1,
+		[]int{2, 0, 2, 1, 1, 0, 4, 1, 5, 2}, []string{"v1", "users", "id"}, "")),
+		func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			span := opentracing.SpanFromContext(r.Context()).(*jaeger.Span)
+			operations = append(operations, span.OperationName())
+			sampled = append(sampled, isSampled(span))
+		})
+
+	handler := initSpan(mux, newRouteResolver(newHTTPRuleFiles(t)), nil)
+	for _, id := range []string{"1", "2", "3"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/users/"+id, nil))
+	}
+
+	// the span is started with the route template
+	assert.Equal(t, []string{"GET /v1/users/{id}", "GET /v1/users/{id}", "GET /v1/users/{id}"}, operations)
+	assert.Equal(t, []bool{true, false, false}, sampled)
+}
+
+func TestInitSpanForm(t *testing.T) {
+	tracer := newTestJaegerTracer(t, JaegerOpts{ServiceName: "test", Sampler: SamplerConst, SamplerParam: 1})
+	defer opentracing.SetGlobalTracer(opentracing.GlobalTracer())
+	opentracing.SetGlobalTracer(tracer)
+
+	var operation string
+	mux := runtime.NewServeMux()
+	mux.Handle("GET", runtime.MustPattern(runtime.NewPattern(1,
+		[]int{2, 0, 2, 1, 1, 0, 4, 1, 5, 2}, []string{"v1", "users", "id"}, "")),
+		func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			operation = opentracing.SpanFromContext(r.Context()).(*jaeger.Span).OperationName()
+			w.Write([]byte(r.Form.Get("fields")))
+		})
+
+	// the gateway falls back to GET with the query parameters in the form of the POST request
+	req := httptest.NewRequest("POST", "/v1/users/1", strings.NewReader("fields=name"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp := httptest.NewRecorder()
+	initSpan(mux, newRouteResolver(newHTTPRuleFiles(t)), nil).ServeHTTP(resp, req)
+
+	assert.Equal(t, "name", resp.Body.String())
+	assert.Equal(t, "POST /v1/users/{id}", operation)
+}